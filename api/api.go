@@ -1,24 +1,33 @@
 package api
 
 import (
+	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"math/rand"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/SkyAPM/go2sky"
 	"github.com/gin-gonic/gin"
-	"github.com/openzipkin/zipkin-go"
-	"github.com/openzipkin/zipkin-go/propagation/b3"
+	"google.golang.org/grpc"
+	"httpbin/pkg/discovery"
+	pb "httpbin/pkg/echo"
 	"httpbin/pkg/logs"
-	"httpbin/pkg/middleware"
 	"httpbin/pkg/model"
 	"httpbin/pkg/options"
+	"httpbin/pkg/probe"
+	"httpbin/pkg/registry"
+	"httpbin/pkg/tracing"
 	"httpbin/pkg/utils"
-	v3 "skywalking.apache.org/repo/goapi/collect/language/agent/v3"
 )
 
+// httpClient calls the next hops, timeouts are set per hop by the request context.
+var httpClient = &http.Client{}
+
 var defaultTraceHeaders = []string{
 	// All applications should propagate x-request-id. This header is
 	// included in access log statements and is used for consistent trace
@@ -38,11 +47,15 @@ var defaultTraceHeaders = []string{
 	"x-datadog-trace-id",
 	"x-datadog-parent-id",
 	"x-datadog-sampling-priority",
+	"x-datadog-tags",
+	"x-datadog-origin",
 
 	// b3 trace headers. Compatible with Zipkin, OpenCensusAgent, and
 	// Stackdriver Istio configurations. Commented out since they are
 	// propagated by the OpenTracing tracer above.
 	"X-B3-TraceId", "X-B3-SpanId", "X-B3-ParentSpanId", "X-B3-Sampled", "X-B3-Flags",
+	// b3 single header.
+	"b3",
 
 	// Jager
 	"uber-trace-id",
@@ -75,12 +88,12 @@ var defaultTraceHeaders = []string{
 	"X-Httpbin-Trace-Service",
 }
 
-func Anything(c *gin.Context) {
+func Anything(c *gin.Context, option *options.Option) {
 	// Simulate business call
 	r := rand.Intn(45) + 5
 	time.Sleep(time.Duration(r) * time.Millisecond)
 	// Return
-	response := NewResponseFromContext(c)
+	response := NewResponseFromContext(c, option)
 	c.JSON(http.StatusOK, response)
 }
 
@@ -103,12 +116,15 @@ func Headers(c *gin.Context) {
 }
 
 func Healthz(c *gin.Context) {
+	if !probe.IsLive() {
+		c.JSON(http.StatusServiceUnavailable, "not healthz")
+		return
+	}
 	c.JSON(http.StatusOK, "healthz")
-	return
 }
 
 func HealthzFile(c *gin.Context) {
-	if utils.FileExisted("./healthz.txt") {
+	if probe.IsLiveFile() {
 		c.JSON(http.StatusOK, "ok")
 		return
 	}
@@ -116,12 +132,15 @@ func HealthzFile(c *gin.Context) {
 }
 
 func Readiness(c *gin.Context) {
+	if !probe.IsReady() {
+		c.JSON(http.StatusServiceUnavailable, "not readiness")
+		return
+	}
 	c.JSON(http.StatusOK, "readiness")
-	return
 }
 
 func ReadinessFile(c *gin.Context) {
-	if utils.FileExisted("./readiness.txt") {
+	if probe.IsReadyFile() {
 		c.JSON(http.StatusOK, "ok")
 		return
 	}
@@ -129,18 +148,43 @@ func ReadinessFile(c *gin.Context) {
 }
 
 func Startup(c *gin.Context) {
+	if !probe.IsStarted() {
+		c.JSON(http.StatusServiceUnavailable, "not startup")
+		return
+	}
 	c.JSON(http.StatusOK, "startup")
-	return
 }
 
 func StartupFile(c *gin.Context) {
-	if utils.FileExisted("./startup.txt") {
+	if probe.IsStartedFile() {
 		c.JSON(http.StatusOK, "ok")
 		return
 	}
 	c.JSON(http.StatusNotFound, "not startup")
 }
 
+// SetProb sets the liveness, readiness or startup probe state from the status query, e.g.
+// PUT /prob/readiness?status=false
+func SetProb(c *gin.Context) {
+	status, err := strconv.ParseBool(c.Query("status"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, "status should be true or false")
+		return
+	}
+	switch c.Param("prob") {
+	case "liveness":
+		probe.SetLive(status)
+	case "readiness":
+		probe.SetReady(status)
+	case "startup":
+		probe.SetStarted(status)
+	default:
+		c.JSON(http.StatusNotFound, "prob should be liveness, readiness or startup")
+		return
+	}
+	c.JSON(http.StatusOK, probe.Current())
+}
+
 func Bool(c *gin.Context) {
 	c.JSON(http.StatusCreated, true)
 }
@@ -157,32 +201,137 @@ func ReponseAnyString(c *gin.Context) {
 	c.JSON(http.StatusOK, model.ResponseAny{Code: 1, Data: "hello"})
 }
 
-func Service(c *gin.Context, option *options.Option) {
+func Service(c *gin.Context, option *options.Option, resolver *discovery.Resolver, tracer tracing.Tracer) {
+	// The query drops pairs with an unencoded ; silently, e.g. of the hop parameters.
+	if _, err := url.ParseQuery(c.Request.URL.RawQuery); err != nil {
+		c.JSON(http.StatusBadRequest, fmt.Sprintf("invalid query, encode ; and & of the services and graph: %v", err))
+		return
+	}
+	if _, ok := c.GetQuery("graph"); ok {
+		ServiceGraph(c, option, resolver, tracer)
+		return
+	}
 	nextServices := c.Query("services")
 	if len(nextServices) == 0 {
 		// Simulate business call
 		r := rand.Intn(45) + 5
 		time.Sleep(time.Duration(r) * time.Millisecond)
 		// Return
-		response := NewResponseFromContext(c)
+		response := NewResponseFromContext(c, option)
 		c.JSON(http.StatusOK, response)
 		return
 	}
 	// Call next service
-	// Pass headers
-	headers := c.Request.Header
 	services := strings.Split(nextServices, ",")
-	nextUrl := ""
-	if len(services) == 1 {
-		nextUrl = "http://" + services[0] + "/"
-	} else {
-		nextUrl = "http://" + services[0] + "/service?services=" + strings.Join(services[1:], ",")
+	body, err := c.GetRawData()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	resp := CallNextService(c.Request.Context(), option, resolver, tracer, c.Request.Method, body, c.Request.Header, services)
+	if len(resp.ContentType) > 0 {
+		c.Header("Content-Type", resp.ContentType)
+	}
+	for _, value := range resp.TraceTLS {
+		c.Writer.Header().Add(TraceTLSHeader, value)
+	}
+	c.Status(resp.Status)
+	_, _ = c.Writer.Write(resp.Body)
+}
+
+// CallNextService calls services[0] passing the rest of the chain, over http or over grpc
+// for grpc://host:port and grpcs://host:port hops, and returns the response of the last hop or of the failed hop.
+func CallNextService(ctx context.Context, option *options.Option, resolver *discovery.Resolver, tracer tracing.Tracer, method string, body []byte, headers http.Header, services []string) *HopResponse {
+	h, err := parseHop(option, method, services[0])
+	if err != nil {
+		return failedHop(&hop{name: services[0]}, 0, http.StatusBadRequest, "", err.Error(), nil)
+	}
+	// Pass headers
+	nextHeaders := NextHopHeaders(headers)
+	contentType := headers.Get("Content-Type")
+	if len(body) > 0 && len(contentType) > 0 {
+		nextHeaders.Set("Content-Type", contentType)
+	}
+	resp := callHop(ctx, h, func(ctx context.Context) (*HopResponse, error) {
+		if h.grpc {
+			return callGrpcService(ctx, option, resolver, tracer, h, nextHeaders, echoServiceMethod, func(ctx context.Context, client pb.EchoClient, opts ...grpc.CallOption) (*pb.ServiceResponse, error) {
+				return client.Service(ctx, &pb.ServiceRequest{
+					Services:    services[1:],
+					Method:      method,
+					Body:        body,
+					ContentType: contentType,
+				}, opts...)
+			})
+		}
+		path := "/"
+		if len(services) > 1 {
+			path = "/service?services=" + url.QueryEscape(strings.Join(services[1:], ","))
+		}
+		return callHttpService(ctx, option, resolver, tracer, method, body, h, nextHeaders, path)
+	})
+	if resp.TLS != nil {
+		resp.TraceTLS = append([]string{formatTraceTLS(h.target(), resp.TLS)}, resp.TraceTLS...)
+	}
+	return resp
+}
+
+// callHttpService calls path of the hop once, over https for https:// hops.
+func callHttpService(ctx context.Context, option *options.Option, resolver *discovery.Resolver, tracer tracing.Tracer, method string, body []byte, h *hop, headers http.Header, path string) (*HopResponse, error) {
+	protocol, client := registry.Http, httpClient
+	if h.tls {
+		var err error
+		if client, err = getHttpsClient(option); err != nil {
+			logs.Errorf("create https client failed: %v", err)
+			return nil, err
+		}
+		protocol = registry.Https
 	}
+	endpoint, done, err := resolver.Resolve(ctx, h.service, string(protocol))
+	if err != nil {
+		logs.Errorf("resolve service %s failed: %v", h.service, err)
+		return nil, err
+	}
+	defer done()
+	nextUrl := string(protocol) + "://" + endpoint.Address() + path
 	logs.Infof("service call nexturl:%s", nextUrl)
-	req, err := http.NewRequest(c.Request.Method, nextUrl, c.Request.Body)
+	var bodyReader io.Reader
+	if len(body) > 0 {
+		bodyReader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, nextUrl, bodyReader)
 	if err != nil {
 		logs.Error(err)
+		return nil, err
 	}
+	// Cloned as the tracers add their headers to the request of every attempt.
+	req.Header = headers.Clone()
+	span := tracer.StartHttpClientSpan(ctx, req)
+	defer span.End()
+	resp, err := client.Do(req)
+	if err != nil {
+		logs.Errorf("execute http call failed: %v", err)
+		span.Error(err.Error())
+		return nil, err
+	}
+	span.Tag(tracing.TagHTTPStatusCode, strconv.Itoa(resp.StatusCode))
+
+	defer resp.Body.Close()
+	bodyBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &HopResponse{
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        bodyBytes,
+		TLS:         newHopTLS(resp.TLS),
+		TraceTLS:    resp.Header.Values(TraceTLSHeader),
+	}, nil
+}
+
+// NextHopHeaders returns the trace headers to propagate with lower case keys, adding this
+// service to the httpbin trace headers.
+func NextHopHeaders(headers http.Header) http.Header {
 	lowerCaseHeader := make(http.Header)
 	for key, value := range headers {
 		headK := strings.ToLower(key)
@@ -207,71 +356,5 @@ func Service(c *gin.Context, option *options.Option) {
 	} else {
 		lowerCaseHeader["x-httpbin-trace-service"] = []string{traceHeader2[0] + "/" + utils.GetServiceName()}
 	}
-
-	req.Header = lowerCaseHeader
-	fn := func(req *http.Request) (*http.Response, error) {
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			logs.Error(err)
-		}
-		return resp, err
-	}
-
-	var resp *http.Response
-	if option.TraceProvider == options.Skywalking {
-		resp, err = traceHttpCallSkywalking(c, req, nextUrl, fn)
-	}
-	if option.TraceProvider == options.Zipkin {
-		resp, err = traceHttpCallZipkin(c, req, nextUrl, fn)
-	}
-	if err != nil {
-		logs.Errorf("execute traceHttpCall failed: %v", err)
-	}
-
-	var bodyBytes []byte
-	bodyBytes, _ = io.ReadAll(resp.Body)
-	c.Header("Content-Type", "application/json")
-	c.String(http.StatusOK, string(bodyBytes))
-}
-
-func traceHttpCallSkywalking(c *gin.Context, req *http.Request, url string, fn func(req *http.Request) (*http.Response, error)) (*http.Response, error) {
-	tracer := go2sky.GetGlobalTracer()
-	if tracer == nil {
-		resp, err := fn(req)
-		return resp, err
-	}
-
-	reqSpan, err := go2sky.GetGlobalTracer().CreateExitSpan(c.Request.Context(), "invoke", url, func(headerKey, headerValue string) error {
-		req.Header.Set(headerKey, headerValue)
-		return nil
-	})
-	if err != nil {
-	}
-	reqSpan.SetComponent(2)
-	reqSpan.SetSpanLayer(v3.SpanLayer_Http) // rpc 调用
-	resp, err2 := fn(req)
-	reqSpan.Tag(go2sky.TagHTTPMethod, http.MethodGet)
-	reqSpan.Tag(go2sky.TagURL, url)
-	reqSpan.End()
-	return resp, err2
-}
-
-func traceHttpCallZipkin(c *gin.Context, req *http.Request, url string, fn func(req *http.Request) (*http.Response, error)) (*http.Response, error) {
-	tracer := middleware.ZipkinGlobalTracer
-	if tracer == nil {
-		resp, err := fn(req)
-		return resp, err
-	}
-
-	reqSpan, _ := tracer.StartSpanFromContext(c.Request.Context(), "invoke")
-	err := b3.InjectHTTP(req)(reqSpan.Context())
-	if err != nil {
-		return nil, err
-	}
-	resp, err2 := fn(req)
-	zipkin.TagHTTPMethod.Set(reqSpan, c.Request.Method)
-	zipkin.TagHTTPUrl.Set(reqSpan, url)
-	reqSpan.Finish()
-	return resp, err2
+	return lowerCaseHeader
 }
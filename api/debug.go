@@ -0,0 +1,40 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"httpbin/pkg/tracing"
+)
+
+// Spans returns the spans kept by the trace recorder, filtered by the trace_id, service,
+// min_duration and max_duration queries, limit returns only the latest spans, e.g.
+// /debug/traces?trace_id=4bf92f3577b34da6a3ce929d0e0e4736&min_duration=10ms.
+func Spans(c *gin.Context, recorder *tracing.Recorder) {
+	filter := tracing.SpanFilter{
+		TraceID: c.Query("trace_id"),
+		Service: c.Query("service"),
+	}
+	var err error
+	if value := c.Query("min_duration"); len(value) > 0 {
+		if filter.MinDuration, err = time.ParseDuration(value); err != nil {
+			c.JSON(http.StatusBadRequest, "invalid min_duration: "+err.Error())
+			return
+		}
+	}
+	if value := c.Query("max_duration"); len(value) > 0 {
+		if filter.MaxDuration, err = time.ParseDuration(value); err != nil {
+			c.JSON(http.StatusBadRequest, "invalid max_duration: "+err.Error())
+			return
+		}
+	}
+	if value := c.Query("limit"); len(value) > 0 {
+		if filter.Limit, err = strconv.Atoi(value); err != nil {
+			c.JSON(http.StatusBadRequest, "invalid limit: "+err.Error())
+			return
+		}
+	}
+	c.JSON(http.StatusOK, recorder.Spans(filter))
+}
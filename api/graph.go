@@ -0,0 +1,273 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"httpbin/pkg/discovery"
+	pb "httpbin/pkg/echo"
+	"httpbin/pkg/model"
+	"httpbin/pkg/options"
+	"httpbin/pkg/tracing"
+	"httpbin/pkg/utils"
+)
+
+const graphCall = "->"
+
+// ServiceGraph calls the hops of a call graph in parallel and returns the call tree with its
+// status. The graph is the graph query, e.g. /service?graph=a->(b,c->d), or a JSON body like
+// {"service": "a", "children": [{"service": "b"}]}.
+func ServiceGraph(c *gin.Context, option *options.Option, resolver *discovery.Resolver, tracer tracing.Tracer) {
+	var graphs []*model.CallGraph
+	var err error
+	if graph, ok := c.GetQuery("graph"); ok {
+		graphs, err = ParseCallGraph(graph)
+	} else {
+		graphs, err = bindCallGraph(c)
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, err.Error())
+		return
+	}
+	result := CallServiceGraph(c.Request.Context(), option, resolver, tracer, c.Request.Header, graphs)
+	c.JSON(result.Status, result)
+}
+
+// CallServiceGraph calls the graphs in parallel, returning the call tree of this service. Its
+// status is 502 if any child failed.
+func CallServiceGraph(ctx context.Context, option *options.Option, resolver *discovery.Resolver, tracer tracing.Tracer, headers http.Header, graphs []*model.CallGraph) *model.CallResult {
+	start := time.Now()
+	result := &model.CallResult{
+		Service:  utils.GetServiceName(),
+		HostName: utils.GetHostName(),
+		Status:   http.StatusOK,
+	}
+	if len(graphs) == 0 {
+		// Simulate business call
+		r := rand.Intn(45) + 5
+		time.Sleep(time.Duration(r) * time.Millisecond)
+	}
+	nextHeaders := NextHopHeaders(headers)
+	result.Children = make([]*model.CallResult, len(graphs))
+	var wg sync.WaitGroup
+	for i, graph := range graphs {
+		wg.Add(1)
+		go func(i int, graph *model.CallGraph) {
+			defer wg.Done()
+			result.Children[i] = callGraphHop(ctx, option, resolver, tracer, nextHeaders, graph)
+		}(i, graph)
+	}
+	wg.Wait()
+	for _, child := range result.Children {
+		if child.Status >= http.StatusBadRequest || len(child.Error) > 0 {
+			result.Status = http.StatusBadGateway
+		}
+	}
+	result.Latency = time.Since(start).String()
+	return result
+}
+
+// callGraphHop calls graph.Service with the children of graph as its graph.
+func callGraphHop(ctx context.Context, option *options.Option, resolver *discovery.Resolver, tracer tracing.Tracer, headers http.Header, graph *model.CallGraph) *model.CallResult {
+	start := time.Now()
+	result := &model.CallResult{}
+	h, err := parseHop(option, http.MethodGet, graph.Service)
+	if err != nil {
+		result.Hop = graph.Service
+		result.Status = http.StatusBadRequest
+		result.Error = err.Error()
+		result.Latency = time.Since(start).String()
+		return result
+	}
+	next := FormatCallGraph(graph.Children)
+	resp := callHop(ctx, h, func(ctx context.Context) (*HopResponse, error) {
+		if h.grpc {
+			return callGrpcService(ctx, option, resolver, tracer, h, headers, echoServiceGraphMethod, func(ctx context.Context, client pb.EchoClient, opts ...grpc.CallOption) (*pb.ServiceResponse, error) {
+				return client.ServiceGraph(ctx, &pb.ServiceGraphRequest{Graph: next}, opts...)
+			})
+		}
+		return callHttpService(ctx, option, resolver, tracer, http.MethodGet, nil, h, headers, "/service?graph="+url.QueryEscape(next))
+	})
+	if serviceError, ok := parseServiceError(resp.Body); ok && resp.Status >= http.StatusBadRequest {
+		if tree, ok := parseCallResult([]byte(serviceError.Body)); ok {
+			// A hop with failed children returns its call tree with a failed status.
+			result = tree
+		} else if len(serviceError.Body) > 0 {
+			result.Error = serviceError.Body
+		} else {
+			result.Error = serviceError.Error
+		}
+	} else if err := json.Unmarshal(resp.Body, result); err != nil {
+		result.Error = fmt.Sprintf("invalid call tree: %v", err)
+	}
+	result.Hop = graph.Service
+	result.Status = resp.Status
+	result.GrpcCode = resp.GrpcCode
+	result.TLS = resp.TLS
+	if resp.Attempts > 1 {
+		result.Attempts = resp.Attempts
+	}
+	result.Latency = time.Since(start).String()
+	return result
+}
+
+// parseCallResult parses the call tree returned by a service, which unlike a
+// model.ServiceError has no hop.
+func parseCallResult(body []byte) (*model.CallResult, bool) {
+	result := &model.CallResult{}
+	if err := json.Unmarshal(body, result); err != nil {
+		return nil, false
+	}
+	return result, len(result.Hop) == 0 && len(result.Service) > 0
+}
+
+// bindCallGraph reads a JSON graph or array of graphs from the request body.
+func bindCallGraph(c *gin.Context) ([]*model.CallGraph, error) {
+	body, err := c.GetRawData()
+	if err != nil {
+		return nil, err
+	}
+	body = bytes.TrimSpace(body)
+	var graphs []*model.CallGraph
+	if len(body) > 0 && body[0] == '{' {
+		graph := &model.CallGraph{}
+		err = json.Unmarshal(body, graph)
+		graphs = append(graphs, graph)
+	} else {
+		err = json.Unmarshal(body, &graphs)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid graph: %v", err)
+	}
+	return graphs, checkCallGraph(graphs)
+}
+
+// checkCallGraph checks the services of a JSON graph can be passed on as the graph query.
+func checkCallGraph(graphs []*model.CallGraph) error {
+	for _, graph := range graphs {
+		if graph == nil || len(strings.TrimSpace(graph.Service)) == 0 {
+			return errors.New("invalid graph: missing service")
+		}
+		if strings.ContainsAny(graph.Service, "(),") || strings.Contains(graph.Service, graphCall) {
+			return fmt.Errorf("invalid graph: invalid service %q", graph.Service)
+		}
+		if err := checkCallGraph(graph.Children); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ParseCallGraph parses the call graph DSL. Hops separated by "," are called in parallel and
+// "->" calls the next hop or the "(...)" group of hops, e.g. a->(b,c->d) calls a, which
+// calls b and c in parallel, c calls d.
+func ParseCallGraph(graph string) ([]*model.CallGraph, error) {
+	p := &graphParser{s: graph}
+	graphs, err := p.parseHops()
+	if err != nil {
+		return nil, err
+	}
+	if p.skipSpaces(); p.pos < len(p.s) {
+		return nil, p.errorf("unexpected %q", p.s[p.pos])
+	}
+	return graphs, nil
+}
+
+// FormatCallGraph returns the DSL of graphs, passed on to the next hop.
+func FormatCallGraph(graphs []*model.CallGraph) string {
+	hops := make([]string, 0, len(graphs))
+	for _, graph := range graphs {
+		hop := strings.TrimSpace(graph.Service)
+		switch len(graph.Children) {
+		case 0:
+		case 1:
+			hop += graphCall + FormatCallGraph(graph.Children)
+		default:
+			hop += graphCall + "(" + FormatCallGraph(graph.Children) + ")"
+		}
+		hops = append(hops, hop)
+	}
+	return strings.Join(hops, ",")
+}
+
+type graphParser struct {
+	s   string
+	pos int
+}
+
+func (p *graphParser) parseHops() ([]*model.CallGraph, error) {
+	p.skipSpaces()
+	if p.pos == len(p.s) {
+		return nil, nil
+	}
+	var graphs []*model.CallGraph
+	for {
+		graph, err := p.parseHop()
+		if err != nil {
+			return nil, err
+		}
+		graphs = append(graphs, graph)
+		if p.skipSpaces(); p.pos == len(p.s) || p.s[p.pos] != ',' {
+			return graphs, nil
+		}
+		p.pos++
+	}
+}
+
+func (p *graphParser) parseHop() (*model.CallGraph, error) {
+	start := p.pos
+	for p.pos < len(p.s) && !strings.HasPrefix(p.s[p.pos:], graphCall) && !strings.ContainsRune("(),", rune(p.s[p.pos])) {
+		p.pos++
+	}
+	graph := &model.CallGraph{Service: strings.TrimSpace(p.s[start:p.pos])}
+	if len(graph.Service) == 0 {
+		return nil, p.errorf("missing service")
+	}
+	if !strings.HasPrefix(p.s[p.pos:], graphCall) {
+		return graph, nil
+	}
+	p.pos += len(graphCall)
+	if p.skipSpaces(); p.pos == len(p.s) || p.s[p.pos] != '(' {
+		child, err := p.parseHop()
+		if err != nil {
+			return nil, err
+		}
+		graph.Children = []*model.CallGraph{child}
+		return graph, nil
+	}
+	p.pos++
+	children, err := p.parseHops()
+	if err != nil {
+		return nil, err
+	}
+	if len(children) == 0 {
+		return nil, p.errorf("missing service")
+	}
+	if p.skipSpaces(); p.pos == len(p.s) || p.s[p.pos] != ')' {
+		return nil, p.errorf("missing )")
+	}
+	p.pos++
+	graph.Children = children
+	return graph, nil
+}
+
+func (p *graphParser) skipSpaces() {
+	for p.pos < len(p.s) && p.s[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *graphParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid graph at %d: %s", p.pos, fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,89 @@
+package api
+
+import (
+	"reflect"
+	"testing"
+
+	"httpbin/pkg/model"
+)
+
+func TestParseCallGraph(t *testing.T) {
+	tests := []struct {
+		graph string
+		want  []*model.CallGraph
+		// Formatted graph, the graph itself if empty.
+		format string
+	}{
+		{graph: "", want: nil},
+		{graph: "a", want: []*model.CallGraph{{Service: "a"}}},
+		{graph: "a,b", want: []*model.CallGraph{{Service: "a"}, {Service: "b"}}},
+		{
+			graph: "a->b->c",
+			want:  []*model.CallGraph{{Service: "a", Children: []*model.CallGraph{{Service: "b", Children: []*model.CallGraph{{Service: "c"}}}}}},
+		},
+		{
+			graph: "a->(b,c->d)",
+			want: []*model.CallGraph{{Service: "a", Children: []*model.CallGraph{
+				{Service: "b"},
+				{Service: "c", Children: []*model.CallGraph{{Service: "d"}}},
+			}}},
+		},
+		{
+			graph: "a->(b->(c,d),e)",
+			want: []*model.CallGraph{{Service: "a", Children: []*model.CallGraph{
+				{Service: "b", Children: []*model.CallGraph{{Service: "c"}, {Service: "d"}}},
+				{Service: "e"},
+			}}},
+		},
+		{
+			// A group of one hop is formatted without parentheses.
+			graph:  "a->(b)",
+			want:   []*model.CallGraph{{Service: "a", Children: []*model.CallGraph{{Service: "b"}}}},
+			format: "a->b",
+		},
+		{
+			graph:  " a -> ( b , grpc://c:9091;timeout=1s ) ",
+			want:   []*model.CallGraph{{Service: "a", Children: []*model.CallGraph{{Service: "b"}, {Service: "grpc://c:9091;timeout=1s"}}}},
+			format: "a->(b,grpc://c:9091;timeout=1s)",
+		},
+	}
+	for _, tt := range tests {
+		got, err := ParseCallGraph(tt.graph)
+		if err != nil {
+			t.Errorf("ParseCallGraph(%q) error: %v", tt.graph, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseCallGraph(%q) = %s, want %s", tt.graph, FormatCallGraph(got), FormatCallGraph(tt.want))
+		}
+		format := tt.format
+		if len(format) == 0 {
+			format = tt.graph
+		}
+		if got := FormatCallGraph(got); got != format {
+			t.Errorf("FormatCallGraph(ParseCallGraph(%q)) = %q, want %q", tt.graph, got, format)
+		}
+	}
+}
+
+func TestParseCallGraphInvalid(t *testing.T) {
+	for _, graph := range []string{
+		",",
+		"a,",
+		"a,,b",
+		"a->",
+		"a->()",
+		"a->(b,)",
+		"a->(,b)",
+		"a->(b",
+		"a->((b))",
+		"a->(b))",
+		"(a)",
+		"a)",
+		"a(b)",
+	} {
+		if got, err := ParseCallGraph(graph); err == nil {
+			t.Errorf("ParseCallGraph(%q) = %s, want error", graph, FormatCallGraph(got))
+		}
+	}
+}
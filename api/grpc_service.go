@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"httpbin/pkg/discovery"
+	pb "httpbin/pkg/echo"
+	"httpbin/pkg/logs"
+	"httpbin/pkg/model"
+	"httpbin/pkg/options"
+	"httpbin/pkg/registry"
+	"httpbin/pkg/tracing"
+)
+
+const (
+	grpcHopPrefix          = "grpc://"
+	grpcsHopPrefix         = "grpcs://"
+	echoServiceMethod      = "/httpbin.Echo/service"
+	echoServiceGraphMethod = "/httpbin.Echo/serviceGraph"
+)
+
+// grpcConns caches a client connection per next hop protocol and address.
+var grpcConns sync.Map
+
+// callGrpcService calls method of the httpbin.Echo service of the hop once with call, which
+// continues the chain on the grpc side. The headers are sent as metadata. A failed hop after
+// the hop is returned as the response, the hop sends its model.ServiceError as the status
+// message. grpcs:// hops use the client certificates of https hops.
+func callGrpcService(ctx context.Context, option *options.Option, resolver *discovery.Resolver, tracer tracing.Tracer, h *hop, headers http.Header, method string,
+	call func(ctx context.Context, client pb.EchoClient, opts ...grpc.CallOption) (*pb.ServiceResponse, error)) (*HopResponse, error) {
+	protocol, port := registry.Grpc, option.GrpcPort
+	if h.tls {
+		protocol = registry.Grpcs
+		if option.GrpcTlsPort > 0 {
+			port = option.GrpcTlsPort
+		}
+	}
+	endpoint, done, err := resolver.Resolve(ctx, h.service, string(protocol))
+	if err != nil {
+		logs.Errorf("resolve service %s failed: %v", h.service, err)
+		return nil, err
+	}
+	defer done()
+	address := endpoint.Address()
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = fmt.Sprintf("%s:%d", address, port)
+	}
+	logs.Infof("service call next %s:%s%s", protocol, address, method)
+	conn, err := grpcConn(option, protocol, address)
+	if err != nil {
+		return nil, err
+	}
+	md := metadata.MD{}
+	for key, values := range headers {
+		md.Append(key, values...)
+	}
+	var header metadata.MD
+	var p peer.Peer
+	span := tracer.StartGrpcClientSpan(ctx, address, method, md)
+	defer span.End()
+	resp, err := call(metadata.NewOutgoingContext(ctx, md), pb.NewEchoClient(conn), grpc.Header(&header), grpc.Peer(&p))
+	var hopTLS *model.HopTLS
+	if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		hopTLS = newHopTLS(&tlsInfo.State)
+	}
+	span.Tag(tracing.TagGRPCStatusCode, status.Code(err).String())
+	if err != nil {
+		logs.Error(err)
+		span.Error(err.Error())
+		st, _ := status.FromError(err)
+		if serviceError, ok := parseServiceError([]byte(st.Message())); ok {
+			return &HopResponse{
+				Status:      serviceError.Status,
+				ContentType: contentTypeJSON,
+				Body:        []byte(st.Message()),
+				GrpcCode:    st.Code().String(),
+				TLS:         hopTLS,
+				TraceTLS:    header.Get(TraceTLSHeader),
+			}, nil
+		}
+		if tree, ok := parseCallResult([]byte(st.Message())); ok {
+			// A graph with failed children returns its call tree as the message.
+			return &HopResponse{
+				Status:      tree.Status,
+				ContentType: contentTypeJSON,
+				Body:        []byte(st.Message()),
+				GrpcCode:    st.Code().String(),
+				TLS:         hopTLS,
+				TraceTLS:    header.Get(TraceTLSHeader),
+			}, nil
+		}
+		return nil, err
+	}
+	return &HopResponse{
+		Status:      http.StatusOK,
+		ContentType: contentTypeJSON,
+		Body:        []byte(resp.Body),
+		GrpcCode:    codes.OK.String(),
+		TLS:         hopTLS,
+		TraceTLS:    header.Get(TraceTLSHeader),
+	}, nil
+}
+
+func grpcConn(option *options.Option, protocol registry.ServiceProtocol, address string) (*grpc.ClientConn, error) {
+	key := string(protocol) + "://" + address
+	if conn, ok := grpcConns.Load(key); ok {
+		return conn.(*grpc.ClientConn), nil
+	}
+	creds := insecure.NewCredentials()
+	if protocol == registry.Grpcs {
+		tlsConfig, err := NewClientTLSConfig(option)
+		if err != nil {
+			logs.Errorf("create grpcs client failed: %v", err)
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	conn, err := grpc.Dial(address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return nil, err
+	}
+	if actual, loaded := grpcConns.LoadOrStore(key, conn); loaded {
+		conn.Close()
+		return actual.(*grpc.ClientConn), nil
+	}
+	return conn, nil
+}
@@ -0,0 +1,263 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc/status"
+	"httpbin/pkg/logs"
+	"httpbin/pkg/model"
+	"httpbin/pkg/options"
+	"httpbin/pkg/transcoding"
+	"httpbin/pkg/utils"
+)
+
+// Parameters of a hop, appended to the hop after hopParamSeparator, e.g.
+// backend;timeout=1s;retries=2. The query parser rejects an unencoded ;, so in a url it is
+// encoded as %3B like backend%3Btimeout=1s, which then does not split the services query.
+const (
+	hopTimeout = "timeout"
+	hopRetries = "retries"
+	hopBackoff = "backoff"
+
+	hopParamSeparator = ";"
+)
+
+const contentTypeJSON = "application/json"
+
+// hop is a next hop of the /service chain or graph. The timeout and retries default to
+// --hop-timeout, --hop-retries and --hop-retry-backoff, --hop-retries only applies to
+// idempotent methods.
+type hop struct {
+	// Hop as named in the services or graph.
+	name string
+	// Service to resolve, without the grpc://, grpcs:// or https:// prefix and parameters.
+	service string
+	grpc    bool
+	// Over TLS, https:// and grpcs:// hops.
+	tls     bool
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+	// Limit of the doubled backoff, --hop-max-retry-backoff.
+	maxBackoff time.Duration
+}
+
+// HopResponse is the response of a next hop. The body of a failed hop is a model.ServiceError.
+type HopResponse struct {
+	Status      int
+	ContentType string
+	Body        []byte
+	GrpcCode    string
+	Attempts    int
+	// TLS of a https hop.
+	TLS *model.HopTLS
+	// TraceTLSHeader values returned by the hop.
+	TraceTLS []string
+}
+
+// parseHop parses the hop called with method. A retries parameter retries any method.
+func parseHop(option *options.Option, method string, name string) (*hop, error) {
+	h := &hop{
+		name:       name,
+		service:    name,
+		timeout:    option.HopTimeout,
+		backoff:    option.HopRetryBackoff,
+		maxBackoff: option.HopMaxBackoff,
+	}
+	if idempotent(method) {
+		h.retries = option.HopRetries
+	}
+	if strings.HasPrefix(h.service, grpcHopPrefix) {
+		h.grpc = true
+		h.service = strings.TrimPrefix(h.service, grpcHopPrefix)
+	} else if strings.HasPrefix(h.service, grpcsHopPrefix) {
+		h.grpc, h.tls = true, true
+		h.service = strings.TrimPrefix(h.service, grpcsHopPrefix)
+	} else if strings.HasPrefix(h.service, httpsHopPrefix) {
+		h.tls = true
+		h.service = strings.TrimPrefix(h.service, httpsHopPrefix)
+	}
+	service, params, found := strings.Cut(h.service, hopParamSeparator)
+	if !found {
+		return h, nil
+	}
+	h.service = service
+	for _, param := range strings.Split(params, hopParamSeparator) {
+		key, value, ok := strings.Cut(param, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid hop %s: %s: missing =", name, param)
+		}
+		var err error
+		switch key {
+		case hopTimeout:
+			if h.timeout, err = time.ParseDuration(value); err == nil && h.timeout < 0 {
+				err = errors.New("negative timeout")
+			}
+		case hopRetries:
+			if h.retries, err = strconv.Atoi(value); err == nil && h.retries < 0 {
+				err = errors.New("negative retries")
+			} else if err == nil && h.retries > option.HopMaxRetries {
+				err = fmt.Errorf("more than --hop-max-retries %d", option.HopMaxRetries)
+			}
+		case hopBackoff:
+			if h.backoff, err = time.ParseDuration(value); err == nil && h.backoff < 0 {
+				err = errors.New("negative backoff")
+			}
+		default:
+			err = errors.New("unknown parameter")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid hop %s: %s: %v", name, key, err)
+		}
+	}
+	return h, nil
+}
+
+// target returns the hop without parameters, e.g. https://backend:443.
+func (h *hop) target() string {
+	target, _, _ := strings.Cut(h.name, hopParamSeparator)
+	return target
+}
+
+// idempotent reports whether requests of method may be retried by default.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// callHop calls the hop, retrying network errors and 5xx responses with exponential
+// backoff. Each attempt is limited by the hop timeout. Failures are returned as a
+// model.ServiceError body with the status of the hop, unless the hop already returned one
+// for a later hop.
+func callHop(ctx context.Context, h *hop, call func(ctx context.Context) (*HopResponse, error)) *HopResponse {
+	var resp *HopResponse
+	var err error
+	attempts := 0
+	for {
+		attempts++
+		resp, err = callHopAttempt(ctx, h, call)
+		if attempts > h.retries || !retryable(resp, err) || ctx.Err() != nil {
+			break
+		}
+		backoff := h.retryBackoff(attempts)
+		logs.Infof("retry hop %s in %s, attempt %d failed: %v", h.name, backoff, attempts, hopFailure(resp, err))
+		if !sleepContext(ctx, backoff) {
+			break
+		}
+	}
+	if err != nil {
+		resp = failedHop(h, attempts, errorStatus(err), grpcCode(err), err.Error(), nil)
+	} else if resp.Status >= http.StatusBadRequest && !isServiceError(resp.Body) {
+		failed := failedHop(h, attempts, resp.Status, resp.GrpcCode, http.StatusText(resp.Status), resp.Body)
+		failed.TLS, failed.TraceTLS = resp.TLS, resp.TraceTLS
+		resp = failed
+	}
+	resp.Attempts = attempts
+	return resp
+}
+
+// retryBackoff returns the backoff after attempts failed attempts, doubled for every retry
+// up to maxBackoff.
+func (h *hop) retryBackoff(attempts int) time.Duration {
+	backoff := h.backoff
+	for i := 1; i < attempts && backoff < h.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if h.maxBackoff > 0 && backoff > h.maxBackoff {
+		return h.maxBackoff
+	}
+	return backoff
+}
+
+func callHopAttempt(ctx context.Context, h *hop, call func(ctx context.Context) (*HopResponse, error)) (*HopResponse, error) {
+	if h.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.timeout)
+		defer cancel()
+	}
+	return call(ctx)
+}
+
+func retryable(resp *HopResponse, err error) bool {
+	if err != nil {
+		return errorStatus(err) >= http.StatusInternalServerError
+	}
+	return resp.Status >= http.StatusInternalServerError
+}
+
+func hopFailure(resp *HopResponse, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return http.StatusText(resp.Status)
+}
+
+func grpcCode(err error) string {
+	if s, ok := status.FromError(err); ok {
+		return s.Code().String()
+	}
+	return ""
+}
+
+// errorStatus returns the http status of a failed call, 504 for timeouts and 503 for
+// other network errors.
+func errorStatus(err error) int {
+	if s, ok := status.FromError(err); ok {
+		return transcoding.HTTPStatusFromCode(s.Code())
+	}
+	if errors.Is(err, context.DeadlineExceeded) || os.IsTimeout(err) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusServiceUnavailable
+}
+
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// failedHop returns the model.ServiceError response of the hop.
+func failedHop(h *hop, attempts int, statusCode int, grpcCode string, reason string, body []byte) *HopResponse {
+	serviceError := &model.ServiceError{
+		Hop:      h.name,
+		Service:  utils.GetServiceName(),
+		HostName: utils.GetHostName(),
+		Status:   statusCode,
+		GrpcCode: grpcCode,
+		Attempts: attempts,
+		Error:    reason,
+		Body:     strings.TrimSpace(string(body)),
+	}
+	bodyBytes, _ := json.Marshal(serviceError)
+	return &HopResponse{Status: statusCode, ContentType: contentTypeJSON, Body: bodyBytes, GrpcCode: grpcCode}
+}
+
+func parseServiceError(body []byte) (*model.ServiceError, bool) {
+	serviceError := &model.ServiceError{}
+	if err := json.Unmarshal(body, serviceError); err != nil {
+		return nil, false
+	}
+	return serviceError, len(serviceError.Hop) > 0 && len(serviceError.Error) > 0
+}
+
+func isServiceError(body []byte) bool {
+	_, ok := parseServiceError(body)
+	return ok
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"httpbin/pkg/options"
+)
+
+func testHopOption() *options.Option {
+	return &options.Option{
+		HopTimeout:      30 * time.Second,
+		HopRetries:      1,
+		HopRetryBackoff: 100 * time.Millisecond,
+		HopMaxRetries:   5,
+		HopMaxBackoff:   time.Second,
+	}
+}
+
+func TestParseHop(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		want   hop
+	}{
+		{
+			name:   "backend",
+			method: http.MethodGet,
+			want:   hop{service: "backend", timeout: 30 * time.Second, retries: 1, backoff: 100 * time.Millisecond},
+		},
+		{
+			// --hop-retries only applies to idempotent methods.
+			name:   "backend",
+			method: http.MethodPost,
+			want:   hop{service: "backend", timeout: 30 * time.Second, backoff: 100 * time.Millisecond},
+		},
+		{
+			name:   "backend:8080;timeout=1s;retries=3;backoff=10ms",
+			method: http.MethodPost,
+			want:   hop{service: "backend:8080", timeout: time.Second, retries: 3, backoff: 10 * time.Millisecond},
+		},
+		{
+			name:   "grpc://orders:9091;retries=0",
+			method: http.MethodGet,
+			want:   hop{service: "orders:9091", grpc: true, timeout: 30 * time.Second, backoff: 100 * time.Millisecond},
+		},
+		{
+			name:   "grpcs://orders:9091;timeout=0s",
+			method: http.MethodGet,
+			want:   hop{service: "orders:9091", grpc: true, tls: true, retries: 1, backoff: 100 * time.Millisecond},
+		},
+		{
+			name:   "https://backend;retries=5",
+			method: http.MethodGet,
+			want:   hop{service: "backend", tls: true, timeout: 30 * time.Second, retries: 5, backoff: 100 * time.Millisecond},
+		},
+	}
+	for _, tt := range tests {
+		got, err := parseHop(testHopOption(), tt.method, tt.name)
+		if err != nil {
+			t.Errorf("parseHop(%s %q) error: %v", tt.method, tt.name, err)
+			continue
+		}
+		want := tt.want
+		want.name, want.maxBackoff = tt.name, time.Second
+		if !reflect.DeepEqual(*got, want) {
+			t.Errorf("parseHop(%s %q) = %+v, want %+v", tt.method, tt.name, *got, want)
+		}
+	}
+}
+
+func TestParseHopInvalid(t *testing.T) {
+	for _, name := range []string{
+		"backend;",
+		"backend;timeout",
+		"backend;timeout=1",
+		"backend;timeout=-1s",
+		"backend;retries=-1",
+		"backend;retries=6",
+		"backend;retries=one",
+		"backend;backoff=-1ms",
+		"backend;unknown=1",
+		"backend;timeout=1s;;retries=1",
+	} {
+		if got, err := parseHop(testHopOption(), http.MethodGet, name); err == nil {
+			t.Errorf("parseHop(%q) = %+v, want error", name, *got)
+		}
+	}
+}
+
+func TestHopTarget(t *testing.T) {
+	h, err := parseHop(testHopOption(), http.MethodGet, "https://backend:443;timeout=1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := h.target(); got != "https://backend:443" {
+		t.Errorf("target = %s, want https://backend:443", got)
+	}
+}
+
+func TestHopRetryBackoff(t *testing.T) {
+	h := &hop{backoff: 100 * time.Millisecond, maxBackoff: time.Second}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{1000, time.Second},
+	}
+	for _, tt := range tests {
+		if got := h.retryBackoff(tt.attempts); got != tt.want {
+			t.Errorf("retryBackoff(%d) = %s, want %s", tt.attempts, got, tt.want)
+		}
+	}
+}
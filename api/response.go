@@ -1,14 +1,19 @@
 package api
 
 import (
+	"context"
+	"net/http"
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
 	"httpbin/pkg/model"
+	"httpbin/pkg/options"
+	"httpbin/pkg/tracing"
 	"httpbin/pkg/utils"
 )
 
-func NewResponseFromContext(c *gin.Context) model.Response {
+func NewResponseFromContext(c *gin.Context, option *options.Option) model.Response {
 	query := c.Request.URL.Query()
 	headers := c.Request.Header
 	form := c.Request.Form
@@ -28,20 +33,7 @@ func NewResponseFromContext(c *gin.Context) model.Response {
 		response.Headers[strings.ToLower(hk)] = strings.Join(hv, ",")
 	}
 
-	// Add  trace header
-	_, ok := response.Headers["x-httpbin-trace-host"]
-	if !ok {
-		response.Headers["x-httpbin-trace-host"] = utils.GetHostName()
-	} else {
-		response.Headers["x-httpbin-trace-host"] = response.Headers["x-httpbin-trace-host"] + "/" + utils.GetHostName()
-	}
-
-	_, ok2 := response.Headers["x-httpbin-trace-service"]
-	if !ok2 {
-		response.Headers["x-httpbin-trace-service"] = utils.GetServiceName()
-	} else {
-		response.Headers["x-httpbin-trace-service"] = response.Headers["x-httpbin-trace-service"] + "/" + utils.GetServiceName()
-	}
+	addTraceHeaders(response.Headers)
 
 	for fk, fv := range form {
 		response.Form[fk] = strings.Join(fv, ",")
@@ -50,6 +42,8 @@ func NewResponseFromContext(c *gin.Context) model.Response {
 	response.Origin = c.Request.Header.Get("Origin")
 	response.Envs = utils.GetAllEnvs()
 	response.HostName = utils.GetHostName()
+	response.Meta = option.ServiceMeta
+	response.Sampling = tracing.SamplingFromContext(c.Request.Context())
 
 	var bodyBytes []byte // 我们需要的body内容
 	// 从原有Request.Body读取
@@ -57,3 +51,41 @@ func NewResponseFromContext(c *gin.Context) model.Response {
 	response.Body = string(bodyBytes)
 	return response
 }
+
+// NewResponseFromGrpc returns the response of a grpc last hop of /service, shaped like
+// NewResponseFromContext so a chain returns the same body whatever its last hop. headers
+// are the incoming metadata, method and body those of the /service request passed on.
+func NewResponseFromGrpc(ctx context.Context, option *options.Option, headers http.Header, method string, body []byte) model.Response {
+	response := model.Response{
+		Args:    make(map[string]string),
+		Form:    make(map[string]string),
+		Headers: make(map[string]string, len(headers)),
+	}
+	response.Method = method
+	response.Url, _ = grpc.Method(ctx)
+	for hk, hv := range headers {
+		response.Headers[strings.ToLower(hk)] = strings.Join(hv, ",")
+	}
+	addTraceHeaders(response.Headers)
+	response.Origin = headers.Get("Origin")
+	response.Envs = utils.GetAllEnvs()
+	response.HostName = utils.GetHostName()
+	response.Meta = option.ServiceMeta
+	response.Sampling = tracing.SamplingFromContext(ctx)
+	response.Body = string(body)
+	return response
+}
+
+// addTraceHeaders appends this host and service to the httpbin trace headers.
+func addTraceHeaders(headers map[string]string) {
+	if host, ok := headers["x-httpbin-trace-host"]; ok {
+		headers["x-httpbin-trace-host"] = host + "/" + utils.GetHostName()
+	} else {
+		headers["x-httpbin-trace-host"] = utils.GetHostName()
+	}
+	if service, ok := headers["x-httpbin-trace-service"]; ok {
+		headers["x-httpbin-trace-service"] = service + "/" + utils.GetServiceName()
+	} else {
+		headers["x-httpbin-trace-service"] = utils.GetServiceName()
+	}
+}
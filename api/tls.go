@@ -0,0 +1,109 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"httpbin/pkg/model"
+	"httpbin/pkg/options"
+)
+
+const httpsHopPrefix = "https://"
+
+// TraceTLSHeader is the response header reporting the server certificate of every https hop
+// of the /service chain, one value per hop in the format of x-forwarded-client-cert, e.g.
+// Hop=https://backend;Subject="CN=backend";URI=spiffe://cluster.local/ns/default/sa/backend
+const TraceTLSHeader = "x-httpbin-trace-tls"
+
+var (
+	httpsClientOnce sync.Once
+	httpsClient     *http.Client
+	httpsClientErr  error
+)
+
+// getHttpsClient returns the client of https hops, created on the first call.
+func getHttpsClient(option *options.Option) (*http.Client, error) {
+	httpsClientOnce.Do(func() {
+		tlsConfig, err := NewClientTLSConfig(option)
+		if err != nil {
+			httpsClientErr = err
+			return
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		httpsClient = &http.Client{Transport: transport}
+	})
+	return httpsClient, httpsClientErr
+}
+
+// NewClientTLSConfig returns the tls config of https hops. Servers are verified by --cacert,
+// or the system roots without it, with --server-name instead of the hop host if set. --cert
+// and --key are presented as the client certificate for mTLS.
+func NewClientTLSConfig(option *options.Option) (*tls.Config, error) {
+	config := &tls.Config{ServerName: option.TlsServerName}
+	if len(option.CACertFile) > 0 {
+		caCert, err := os.ReadFile(option.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		caCertPool := x509.NewCertPool()
+		if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
+			return nil, errors.New("failed to add CA certificate to pool")
+		}
+		config.RootCAs = caCertPool
+	}
+	if len(option.TlsCertFile) > 0 && len(option.TlsKeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(option.TlsCertFile, option.TlsKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func newHopTLS(state *tls.ConnectionState) *model.HopTLS {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	cert := state.PeerCertificates[0]
+	hopTLS := &model.HopTLS{
+		Version:     tls.VersionName(state.Version),
+		CipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		ServerName:  state.ServerName,
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		DNSNames:    cert.DNSNames,
+		NotAfter:    cert.NotAfter.UTC().Format(time.RFC3339),
+	}
+	for _, uri := range cert.URIs {
+		hopTLS.URIs = append(hopTLS.URIs, uri.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		hopTLS.IPAddresses = append(hopTLS.IPAddresses, ip.String())
+	}
+	return hopTLS
+}
+
+// formatTraceTLS returns the TraceTLSHeader value of a hop.
+func formatTraceTLS(hop string, hopTLS *model.HopTLS) string {
+	elements := []string{
+		"Hop=" + hop,
+		fmt.Sprintf("Subject=%q", hopTLS.Subject),
+		fmt.Sprintf("Issuer=%q", hopTLS.Issuer),
+	}
+	for _, uri := range hopTLS.URIs {
+		elements = append(elements, "URI="+uri)
+	}
+	for _, dns := range hopTLS.DNSNames {
+		elements = append(elements, "DNS="+dns)
+	}
+	return strings.Join(elements, ";")
+}
@@ -4,21 +4,28 @@ import (
 	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/nacos-group/nacos-sdk-go/v2/common/logger"
 	"github.com/spf13/cobra"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
 	"httpbin/api"
+	"httpbin/pkg/discovery"
+	echopb "httpbin/pkg/echo"
 	"httpbin/pkg/logs"
 	"httpbin/pkg/middleware"
 	"httpbin/pkg/options"
 	pb "httpbin/pkg/order"
+	"httpbin/pkg/probe"
 	"httpbin/pkg/registry"
+	"httpbin/pkg/tracing"
+	"httpbin/pkg/transcoding"
 	"io/ioutil"
-	"log"
 	"net"
 	"net/http"
 )
@@ -31,6 +38,9 @@ func NewAppCommand(ctx context.Context) *cobra.Command {
 		Run: func(cmd *cobra.Command, args []string) {
 			logs.Infof("run with option:%+v", option)
 			option.Complete()
+			if err := option.Validate(); err != nil {
+				logs.Fatal(err)
+			}
 			if err := Run(ctx, option); err != nil {
 				logs.Fatal(err)
 			}
@@ -44,23 +54,43 @@ func NewAppCommand(ctx context.Context) *cobra.Command {
 
 func Run(ctx context.Context, option *options.Option) error {
 	r := gin.New()
+	lifecycle := NewLifecycle(option)
+
 	// Start Trace
-	if option.TraceProvider == options.Skywalking {
-		middleware.StartSkywalkingTracer(r, option)
+	recorder, err := tracing.NewRecorder(option)
+	if err != nil {
+		return err
+	}
+	tracer, err := tracing.NewTracer(ctx, option, recorder)
+	if err != nil {
+		return err
 	}
-	if option.TraceProvider == options.Zipkin {
-		middleware.StartZipkinTracer(r, option)
+	r.Use(tracer.Middleware())
+	lifecycle.AddStopHook(tracer.Shutdown)
+	if recorder != nil {
+		lifecycle.AddStopHook(func(ctx context.Context) error {
+			return recorder.Close()
+		})
 	}
 	// Start Metric
 	middleware.StartMetric(r, option)
 	// Start Log
 	middleware.StartLogger(r, option)
 
-	// Start Service Registry
-	registry.StartRegistry(ctx, option)
+	// Service Registry, registered once all listeners are created
+	serviceRegistry, err := registry.NewServiceRegistry(option)
+	if err != nil {
+		return err
+	}
 
-	r.GET("/", api.Anything)
-	r.POST("/", api.Anything)
+	anything := func(c *gin.Context) {
+		api.Anything(c, option)
+	}
+	// Methods of /service, passed on to the next hops and the last hop /.
+	serviceMethods := []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete}
+	for _, method := range serviceMethods {
+		r.Handle(method, "/", anything)
+	}
 	r.GET("/hostname", api.HostName)
 	r.GET("/headers", api.Headers)
 	r.GET("/ping", api.Ping)
@@ -72,6 +102,14 @@ func Run(ctx context.Context, option *options.Option) error {
 	r.GET("/prob/readinessfile", api.ReadinessFile)
 	r.GET("/prob/startup", api.Startup)
 	r.GET("/prob/startupfile", api.StartupFile)
+	r.PUT("/prob/:prob", api.SetProb)
+
+	// Spans kept by the trace recorder
+	if option.TraceBufferSize > 0 {
+		r.GET("/debug/traces", func(c *gin.Context) {
+			api.Spans(c, recorder)
+		})
+	}
 
 	// Test any data
 	r.GET("/data/bool", api.Bool)
@@ -80,72 +118,177 @@ func Run(ctx context.Context, option *options.Option) error {
 	r.GET("/data/string", api.ReponseAnyString)
 
 	// Service call
-	r.GET("/service", func(c *gin.Context) {
-		api.Service(c, option)
+	resolver, err := discovery.NewResolver(option, serviceRegistry)
+	if err != nil {
+		return err
+	}
+	service := func(c *gin.Context) {
+		api.Service(c, option, resolver, tracer)
+	}
+	for _, method := range serviceMethods {
+		r.Handle(method, "/service", service)
+	}
+	r.POST("/service/graph", func(c *gin.Context) {
+		api.ServiceGraph(c, option, resolver, tracer)
 	})
 
-	go func() {
-		if runErr := InitGrpc(ctx, option); runErr != nil {
-			logger.Errorf("grpc serve failed with err: %v", runErr)
-		}
-	}()
-	go func() {
-		if runErr := InitHttps(ctx, r, option); runErr != nil {
-			logger.Errorf("https serve failed with err: %v", runErr)
-		}
-	}()
-	r.Run(option.ServerAddress)
+	// OrderManagement over grpc and REST/JSON
+	orderStore, err := NewOrderStore(option)
+	if err != nil {
+		return err
+	}
+	orderManagement := NewOrderManagementImpl(orderStore)
+	err = transcoding.Register(r, &pb.OrderManagement_ServiceDesc, orderManagement,
+		transcoding.WithQueryAlias("q", "value"),
+		transcoding.WithUnaryInterceptor(middleware.GrpcUnaryInterceptor(option)),
+		transcoding.WithStreamInterceptor(middleware.GrpcStreamInterceptor(option)))
+	if err != nil {
+		return err
+	}
+
+	if err := startServers(ctx, lifecycle, option, r, serviceRegistry, tracer, orderManagement, NewEchoImpl(option, resolver, tracer)); err != nil {
+		lifecycle.Close()
+		return err
+	}
+	return lifecycle.Run(ctx)
+}
+
+// startServers creates the listeners of all servers, then registers the endpoints so that
+// a failed start leaves no instance behind in the registry.
+func startServers(ctx context.Context, lifecycle *Lifecycle, option *options.Option, r *gin.Engine, serviceRegistry registry.ServiceRegistry,
+	tracer tracing.Tracer, orderManagement pb.OrderManagementServer, echo echopb.EchoServer) error {
+	if err := InitGrpc(ctx, lifecycle, option, tracer, orderManagement, echo); err != nil {
+		return err
+	}
+	if err := InitHttps(ctx, lifecycle, r, option); err != nil {
+		return err
+	}
+	err := lifecycle.AddHttpServer("http", &http.Server{
+		Addr:    option.ServerAddress,
+		Handler: r,
+	}, "", "")
+	if err != nil {
+		return err
+	}
+	if serviceRegistry == nil {
+		return nil
+	}
+	services, err := registry.StartRegistry(ctx, option, serviceRegistry)
+	if err != nil {
+		return err
+	}
+	lifecycle.AddShutdownHook(func(ctx context.Context) error {
+		return registry.StopRegistry(ctx, serviceRegistry, services)
+	})
 	return nil
 }
 
-func InitGrpc(ctx context.Context, option *options.Option) error {
+func InitGrpc(ctx context.Context, lifecycle *Lifecycle, option *options.Option, tracer tracing.Tracer, orderManagement pb.OrderManagementServer, echo echopb.EchoServer) error {
 	if option.GrpcEnable {
-		logger.Infof("start grpc serve on port: %d", option.GrpcPort)
-		s := grpc.NewServer()
-		pb.RegisterOrderManagementServer(s, &OrderManagementImpl{})
-		// Register reflection service on gRPC server.
-		reflection.Register(s)
-		lit, err := net.Listen("tcp", fmt.Sprintf(":%d", option.GrpcPort))
-		if err != nil {
-			return err
+		// Register health service for Kubernetes, registry and Envoy grpc checks, driven by the probe state.
+		healthServer := probe.NewHealthServer(pb.OrderManagement_ServiceDesc.ServiceName, echopb.Echo_ServiceDesc.ServiceName)
+		if option.GrpcPlaintext() {
+			logger.Infof("start grpc serve on port: %d", option.GrpcPort)
+			conns := middleware.NewGrpcFaultConns()
+			s := newGrpcServer(option, tracer, conns, orderManagement, echo, healthServer)
+			if err := serveGrpc(lifecycle, option, "grpc", s, conns, option.GrpcPort, nil); err != nil {
+				return err
+			}
 		}
-		if err2 := s.Serve(lit); err2 != nil {
-			return err2
+		if option.GrpcTls {
+			logger.Infof("start grpc tls serve on port: %d", option.GrpcTlsPort)
+			tlsConfig, err := NewServerTLSConfig(option)
+			if err != nil {
+				return err
+			}
+			cert, err := tls.LoadX509KeyPair(option.TlsCertFile, option.TlsKeyFile)
+			if err != nil {
+				return err
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+			conns := middleware.NewGrpcFaultConns()
+			var opts []grpc.ServerOption
+			if !option.GrpcStreamReset {
+				opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+			}
+			s := newGrpcServer(option, tracer, conns, orderManagement, echo, healthServer, opts...)
+			if err := serveGrpc(lifecycle, option, "grpc-tls", s, conns, option.GrpcTlsPort, tlsConfig); err != nil {
+				return err
+			}
 		}
 	}
 	return nil
 }
 
-func InitHttps(ctx context.Context, engine *gin.Engine, option *options.Option) error {
+func newGrpcServer(option *options.Option, tracer tracing.Tracer, conns *middleware.GrpcFaultConns, orderManagement pb.OrderManagementServer, echo echopb.EchoServer, healthServer healthpb.HealthServer, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(append(middleware.GrpcServerOptions(option, tracer, conns), opts...)...)
+	pb.RegisterOrderManagementServer(s, orderManagement)
+	echopb.RegisterEchoServer(s, echo)
+	healthpb.RegisterHealthServer(s, healthServer)
+	// Register reflection service on gRPC server.
+	reflection.Register(s)
+	return s
+}
+
+// serveGrpc serves s on port, with the grpc transport or with the Go http2 server for
+// --grpc-stream-reset. tlsConfig is only used by the http2 server, the grpc transport gets
+// it from the grpc.Creds of s.
+func serveGrpc(lifecycle *Lifecycle, option *options.Option, name string, s *grpc.Server, conns *middleware.GrpcFaultConns, port uint32, tlsConfig *tls.Config) error {
+	lit, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	lis := conns.Listener(lit)
+	if !option.GrpcStreamReset {
+		lifecycle.AddGrpcServer(name, s, lis)
+		return nil
+	}
+	srv, err := newHttp2GrpcServer(s, lis, tlsConfig)
+	if err != nil {
+		_ = lis.Close()
+		return err
+	}
+	lifecycle.AddGrpcHttp2Server(name, srv)
+	return nil
+}
+
+// NewServerTLSConfig returns the tls config of the https and grpc servers, trusting client
+// certificates signed by CACertFile and requiring them with MTLS.
+func NewServerTLSConfig(option *options.Option) (*tls.Config, error) {
+	// 加载 CA 根证书
+	caCert, err := ioutil.ReadFile(option.CACertFile)
+	if err != nil {
+		logger.Errorf("Failed to read CA certificate: %v", err)
+		return nil, err
+	}
+
+	caCertPool := x509.NewCertPool()
+	if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
+		logger.Errorf("Failed to add CA certificate to pool")
+		return nil, errors.New("failed to add CA certificate to pool")
+	}
+
+	// 配置 TLS
+	if option.MTLS {
+		return &tls.Config{
+			ClientCAs:  caCertPool,                     // 设置客户端信任的 CA
+			ClientAuth: tls.RequireAndVerifyClientCert, // 强制要求客户端证书
+		}, nil
+	}
+	return &tls.Config{
+		ClientCAs:  caCertPool, // 设置客户端信任的 CA
+		ClientAuth: tls.NoClientCert,
+	}, nil
+}
+
+func InitHttps(ctx context.Context, lifecycle *Lifecycle, engine *gin.Engine, option *options.Option) error {
 	if option.HttpsEnable {
 		logger.Infof("start https serve on port: %d", option.HttpsPort)
-		// 加载 CA 根证书
-		caCert, err := ioutil.ReadFile(option.CACertFile)
+		tlsConfig, err := NewServerTLSConfig(option)
 		if err != nil {
-			logger.Errorf("Failed to read CA certificate: %v", err)
-			return err
-		}
-
-		caCertPool := x509.NewCertPool()
-		if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
-			logger.Errorf("Failed to add CA certificate to pool")
 			return err
 		}
 
-		// 配置 TLS
-		var tlsConfig *tls.Config
-		if option.MTLS {
-			tlsConfig = &tls.Config{
-				ClientCAs:  caCertPool,                     // 设置客户端信任的 CA
-				ClientAuth: tls.RequireAndVerifyClientCert, // 强制要求客户端证书
-			}
-		} else {
-			tlsConfig = &tls.Config{
-				ClientCAs:  caCertPool, // 设置客户端信任的 CA
-				ClientAuth: tls.NoClientCert,
-			}
-		}
-
 		// 创建 HTTPS 服务器
 		server := &http.Server{
 			Addr:      fmt.Sprintf(":%d", option.HttpsPort),
@@ -153,11 +296,7 @@ func InitHttps(ctx context.Context, engine *gin.Engine, option *options.Option)
 			TLSConfig: tlsConfig, // 配置 TLS
 		}
 
-		// 启动 HTTPS 服务器
-		if err := server.ListenAndServeTLS(option.TlsCertFile, option.TlsKeyFile); err != nil {
-			log.Fatalf("Failed to start server: %v", err)
-			return err
-		}
+		return lifecycle.AddHttpServer("https", server, option.TlsCertFile, option.TlsKeyFile)
 	}
 	return nil
 }
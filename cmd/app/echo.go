@@ -0,0 +1,287 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	// Registers google.rpc error details, so reflection clients can encode them in EchoRequest.details.
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails"
+	spb "google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"httpbin/api"
+	"httpbin/pkg/discovery"
+	pb "httpbin/pkg/echo"
+	"httpbin/pkg/options"
+	"httpbin/pkg/tracing"
+	"httpbin/pkg/transcoding"
+	"httpbin/pkg/utils"
+)
+
+type EchoImpl struct {
+	pb.UnimplementedEchoServer
+	option   *options.Option
+	resolver *discovery.Resolver
+	tracer   tracing.Tracer
+}
+
+func NewEchoImpl(option *options.Option, resolver *discovery.Resolver, tracer tracing.Tracer) *EchoImpl {
+	return &EchoImpl{option: option, resolver: resolver, tracer: tracer}
+}
+
+func (s *EchoImpl) Peer(ctx context.Context, _ *emptypb.Empty) (*pb.PeerInfo, error) {
+	return newPeerInfo(ctx), nil
+}
+
+func (s *EchoImpl) Unary(ctx context.Context, req *pb.EchoRequest) (*pb.EchoResponse, error) {
+	sendMetadata(req, func(md metadata.MD) error { return grpc.SetHeader(ctx, md) }, func(md metadata.MD) { _ = grpc.SetTrailer(ctx, md) })
+	if err := delay(ctx, req); err != nil {
+		return nil, err
+	}
+	if err := requestStatus(req); err != nil {
+		return nil, err
+	}
+	return s.newEchoResponse(ctx, req.Message, 0), nil
+}
+
+func (s *EchoImpl) ServerStream(req *pb.EchoRequest, stream pb.Echo_ServerStreamServer) error {
+	ctx := stream.Context()
+	sendMetadata(req, stream.SetHeader, stream.SetTrailer)
+	count := req.Count
+	if count <= 0 {
+		count = 1
+	}
+	for i := int32(0); i < count; i++ {
+		if err := delay(ctx, req); err != nil {
+			return err
+		}
+		if err := stream.Send(s.newEchoResponse(ctx, req.Message, i)); err != nil {
+			return err
+		}
+	}
+	return requestStatus(req)
+}
+
+func (s *EchoImpl) ClientStream(stream pb.Echo_ClientStreamServer) error {
+	ctx := stream.Context()
+	last := &pb.EchoRequest{}
+	messages := make([]string, 0)
+	for {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		messages = append(messages, req.Message)
+		last = req
+	}
+	sendMetadata(last, stream.SetHeader, stream.SetTrailer)
+	if err := delay(ctx, last); err != nil {
+		return err
+	}
+	if err := requestStatus(last); err != nil {
+		return err
+	}
+	return stream.SendAndClose(s.newEchoResponse(ctx, strings.Join(messages, ","), int32(len(messages))))
+}
+
+func (s *EchoImpl) BidiStream(stream pb.Echo_BidiStreamServer) error {
+	ctx := stream.Context()
+	for i := int32(0); ; i++ {
+		req, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		// Headers can only be sent with the first response.
+		sendMetadata(req, stream.SetHeader, stream.SetTrailer)
+		if err := delay(ctx, req); err != nil {
+			return err
+		}
+		if err := requestStatus(req); err != nil {
+			return err
+		}
+		if err := stream.Send(s.newEchoResponse(ctx, req.Message, i)); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *EchoImpl) Service(ctx context.Context, req *pb.ServiceRequest) (*pb.ServiceResponse, error) {
+	headers := incomingHeaders(ctx)
+	method := req.Method
+	if len(method) == 0 {
+		method = http.MethodGet
+	}
+	if len(req.Services) == 0 {
+		// Last hop, respond like the / of an http last hop.
+		body, err := json.Marshal(api.NewResponseFromGrpc(ctx, s.option, headers, method, req.Body))
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return &pb.ServiceResponse{Body: string(body)}, nil
+	}
+	if len(req.ContentType) > 0 {
+		headers.Set("Content-Type", req.ContentType)
+	}
+	resp := api.CallNextService(ctx, s.option, s.resolver, s.tracer, method, req.Body, headers, req.Services)
+	if len(resp.TraceTLS) > 0 {
+		_ = grpc.SetHeader(ctx, metadata.MD{api.TraceTLSHeader: resp.TraceTLS})
+	}
+	if resp.Status >= http.StatusBadRequest {
+		// The model.ServiceError body is passed on as the message, so the caller can return it.
+		return nil, status.Error(transcoding.CodeFromHTTPStatus(resp.Status), string(resp.Body))
+	}
+	return &pb.ServiceResponse{Body: string(resp.Body)}, nil
+}
+
+func (s *EchoImpl) ServiceGraph(ctx context.Context, req *pb.ServiceGraphRequest) (*pb.ServiceResponse, error) {
+	graphs, err := api.ParseCallGraph(req.Graph)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	result := api.CallServiceGraph(ctx, s.option, s.resolver, s.tracer, incomingHeaders(ctx), graphs)
+	body, err := json.Marshal(result)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if result.Status >= http.StatusBadRequest {
+		// The call tree is passed on as the message, so the caller keeps the failed hops.
+		return nil, status.Error(transcoding.CodeFromHTTPStatus(result.Status), string(body))
+	}
+	return &pb.ServiceResponse{Body: string(body)}, nil
+}
+
+// incomingHeaders returns the incoming metadata as http headers for the next hops.
+func incomingHeaders(ctx context.Context) http.Header {
+	md, _ := metadata.FromIncomingContext(ctx)
+	headers := make(http.Header, len(md))
+	for k, v := range md {
+		headers[k] = v
+	}
+	return headers
+}
+
+func (s *EchoImpl) newEchoResponse(ctx context.Context, message string, sequence int32) *pb.EchoResponse {
+	response := &pb.EchoResponse{
+		Message:  message,
+		Metadata: make(map[string]string),
+		Peer:     newPeerInfo(ctx),
+		HostName: utils.GetHostName(),
+		Envs:     utils.GetAllEnvs(),
+		Meta:     s.option.ServiceMeta,
+		Sequence: sequence,
+	}
+	if method, ok := grpc.Method(ctx); ok {
+		response.Method = method
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for k, v := range md {
+		response.Metadata[k] = strings.Join(v, ",")
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		response.DeadlineRemaining = durationpb.New(time.Until(deadline))
+	}
+	return response
+}
+
+func sendMetadata(req *pb.EchoRequest, setHeader func(metadata.MD) error, setTrailer func(metadata.MD)) {
+	if len(req.Headers) > 0 {
+		_ = setHeader(metadata.New(req.Headers))
+	}
+	if len(req.Trailers) > 0 {
+		setTrailer(metadata.New(req.Trailers))
+	}
+}
+
+// delay sleeps for the request delay or until the call is done.
+func delay(ctx context.Context, req *pb.EchoRequest) error {
+	if req.Delay == nil {
+		return nil
+	}
+	timer := time.NewTimer(req.Delay.AsDuration())
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// requestStatus returns the status requested by code, error_message and details.
+func requestStatus(req *pb.EchoRequest) error {
+	if codes.Code(req.Code) == codes.OK {
+		return nil
+	}
+	return status.FromProto(&spb.Status{
+		Code:    req.Code,
+		Message: req.ErrorMessage,
+		Details: req.Details,
+	}).Err()
+}
+
+func newPeerInfo(ctx context.Context) *pb.PeerInfo {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return &pb.PeerInfo{}
+	}
+	info := &pb.PeerInfo{Address: p.Addr.String()}
+	if p.AuthInfo == nil {
+		return info
+	}
+	info.AuthType = p.AuthInfo.AuthType()
+	if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+		info.Tls = newTLSInfo(tlsInfo.State)
+	}
+	return info
+}
+
+func newTLSInfo(state tls.ConnectionState) *pb.TLSInfo {
+	info := &pb.TLSInfo{
+		Version:            tls.VersionName(state.Version),
+		CipherSuite:        tls.CipherSuiteName(state.CipherSuite),
+		ServerName:         state.ServerName,
+		NegotiatedProtocol: state.NegotiatedProtocol,
+	}
+	for _, cert := range state.PeerCertificates {
+		info.PeerCertificates = append(info.PeerCertificates, newCertificate(cert))
+	}
+	return info
+}
+
+func newCertificate(cert *x509.Certificate) *pb.Certificate {
+	c := &pb.Certificate{
+		Subject:        cert.Subject.String(),
+		Issuer:         cert.Issuer.String(),
+		SerialNumber:   cert.SerialNumber.String(),
+		DnsNames:       cert.DNSNames,
+		EmailAddresses: cert.EmailAddresses,
+		NotBefore:      timestamppb.New(cert.NotBefore),
+		NotAfter:       timestamppb.New(cert.NotAfter),
+	}
+	for _, uri := range cert.URIs {
+		c.Uris = append(c.Uris, uri.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		c.IpAddresses = append(c.IpAddresses, ip.String())
+	}
+	return c
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc"
+	"httpbin/pkg/logs"
+	"httpbin/pkg/middleware"
+)
+
+// http2GrpcServer serves a grpc server with the Go http2 server instead of the grpc
+// transport, for --grpc-stream-reset. An aborted handler resets only its own stream, which
+// the grpc transport cannot do.
+type http2GrpcServer struct {
+	lis       net.Listener
+	tlsConfig *tls.Config
+	server    *http.Server
+	h2        *http2.Server
+
+	mu    sync.Mutex
+	conns map[net.Conn]struct{}
+	wg    sync.WaitGroup
+}
+
+// newHttp2GrpcServer serves s on lis, over TLS when tlsConfig is not nil.
+func newHttp2GrpcServer(s *grpc.Server, lis net.Listener, tlsConfig *tls.Config) (*http2GrpcServer, error) {
+	server := &http.Server{Handler: middleware.GrpcStreamResetHandler(s)}
+	h2 := &http2.Server{}
+	// Sends GOAWAY to the served connections on server.Shutdown.
+	if err := http2.ConfigureServer(server, h2); err != nil {
+		return nil, err
+	}
+	if tlsConfig != nil {
+		tlsConfig = tlsConfig.Clone()
+		tlsConfig.NextProtos = []string{http2.NextProtoTLS}
+	}
+	return &http2GrpcServer{
+		lis:       lis,
+		tlsConfig: tlsConfig,
+		server:    server,
+		h2:        h2,
+		conns:     make(map[net.Conn]struct{}),
+	}, nil
+}
+
+func (s *http2GrpcServer) Serve() error {
+	for {
+		conn, err := s.lis.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		s.track(conn, true)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer s.track(conn, false)
+			s.serveConn(conn)
+		}()
+	}
+}
+
+func (s *http2GrpcServer) serveConn(conn net.Conn) {
+	defer conn.Close()
+	if s.tlsConfig != nil {
+		tlsConn := tls.Server(conn, s.tlsConfig)
+		if err := tlsConn.Handshake(); err != nil {
+			logs.Debugf("grpc tls handshake from %s failed with err: %v", conn.RemoteAddr(), err)
+			return
+		}
+		conn = tlsConn
+	}
+	s.h2.ServeConn(conn, &http2.ServeConnOpts{BaseConfig: s.server})
+}
+
+func (s *http2GrpcServer) track(conn net.Conn, add bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add {
+		s.conns[conn] = struct{}{}
+	} else {
+		delete(s.conns, conn)
+	}
+}
+
+// Shutdown stops accepting connections and sends GOAWAY to the served connections, which
+// close once their streams are done. The remaining connections are closed when ctx is done.
+func (s *http2GrpcServer) Shutdown(ctx context.Context) error {
+	_ = s.lis.Close()
+	_ = s.server.Shutdown(ctx)
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		for conn := range s.conns {
+			_ = conn.Close()
+		}
+		s.mu.Unlock()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,190 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"httpbin/pkg/logs"
+	"httpbin/pkg/options"
+	"httpbin/pkg/probe"
+)
+
+// ShutdownHook runs after readiness fails and before listeners are drained.
+type ShutdownHook func(ctx context.Context) error
+
+type server struct {
+	name     string
+	serve    func() error
+	shutdown func(ctx context.Context) error
+	// close closes the listener of a server that never served.
+	close func() error
+}
+
+// Lifecycle owns all listeners of httpbin. It serves them until the signal
+// context is cancelled, then fails readiness, runs shutdown hooks and drains
+// in-flight requests and streams within the configured grace period.
+type Lifecycle struct {
+	option  *options.Option
+	servers []*server
+	hooks   []ShutdownHook
+	stops   []ShutdownHook
+}
+
+func NewLifecycle(option *options.Option) *Lifecycle {
+	return &Lifecycle{option: option}
+}
+
+// AddHttpServer listens on the Addr of an HTTP server and adds it. When srv has a
+// TLSConfig it serves TLS with certFile and keyFile.
+func (l *Lifecycle) AddHttpServer(name string, srv *http.Server, certFile, keyFile string) error {
+	lis, err := net.Listen("tcp", srv.Addr)
+	if err != nil {
+		return err
+	}
+	l.servers = append(l.servers, &server{
+		name: name,
+		serve: func() error {
+			var err error
+			if srv.TLSConfig != nil {
+				err = srv.ServeTLS(lis, certFile, keyFile)
+			} else {
+				err = srv.Serve(lis)
+			}
+			if errors.Is(err, http.ErrServerClosed) {
+				return nil
+			}
+			return err
+		},
+		shutdown: srv.Shutdown,
+		close:    lis.Close,
+	})
+	return nil
+}
+
+// AddGrpcServer adds a gRPC server serving on lis.
+func (l *Lifecycle) AddGrpcServer(name string, srv *grpc.Server, lis net.Listener) {
+	l.servers = append(l.servers, &server{
+		name: name,
+		serve: func() error {
+			return srv.Serve(lis)
+		},
+		shutdown: func(ctx context.Context) error {
+			done := make(chan struct{})
+			go func() {
+				srv.GracefulStop()
+				close(done)
+			}()
+			select {
+			case <-done:
+				return nil
+			case <-ctx.Done():
+				srv.Stop()
+				return ctx.Err()
+			}
+		},
+		close: lis.Close,
+	})
+}
+
+// AddGrpcHttp2Server adds a gRPC server served with the Go http2 server.
+func (l *Lifecycle) AddGrpcHttp2Server(name string, srv *http2GrpcServer) {
+	l.servers = append(l.servers, &server{
+		name:     name,
+		serve:    srv.Serve,
+		shutdown: srv.Shutdown,
+		close:    srv.lis.Close,
+	})
+}
+
+// Close closes the listeners when httpbin fails to start, before Run.
+func (l *Lifecycle) Close() {
+	for _, s := range l.servers {
+		if err := s.close(); err != nil {
+			logs.Errorf("%s close failed with err: %v", s.name, err)
+		}
+	}
+}
+
+// AddShutdownHook adds a hook run in order when shutdown starts.
+func (l *Lifecycle) AddShutdownHook(hook ShutdownHook) {
+	l.hooks = append(l.hooks, hook)
+}
+
+// AddStopHook adds a hook run in order after the servers are drained, e.g. to flush
+// what the last requests produced.
+func (l *Lifecycle) AddStopHook(hook ShutdownHook) {
+	l.stops = append(l.stops, hook)
+}
+
+// Run serves all servers and blocks until ctx is cancelled or a server fails,
+// then shuts everything down gracefully.
+func (l *Lifecycle) Run(ctx context.Context) error {
+	errCh := make(chan error, len(l.servers))
+	for _, s := range l.servers {
+		go func(s *server) {
+			logs.Infof("start %s serve", s.name)
+			if err := s.serve(); err != nil {
+				logs.Errorf("%s serve failed with err: %v", s.name, err)
+				errCh <- err
+			}
+		}(s)
+	}
+
+	var runErr error
+	select {
+	case <-ctx.Done():
+		logs.Infof("shutdown signal received")
+	case runErr = <-errCh:
+	}
+	l.shutdown()
+	return runErr
+}
+
+func (l *Lifecycle) shutdown() {
+	// One deadline for the whole shutdown including the delay, so it ends within the grace
+	// period and the terminationGracePeriodSeconds of the pod.
+	ctx, cancel := context.WithTimeout(context.Background(), l.option.ShutdownGracePeriod)
+	defer cancel()
+
+	// Fail readiness first so load balancers and kubelet stop sending traffic.
+	probe.SetReady(false)
+
+	for _, hook := range l.hooks {
+		if err := hook(ctx); err != nil {
+			logs.Errorf("shutdown hook failed with err: %v", err)
+		}
+	}
+
+	logs.Infof("wait %v before draining listeners", l.option.ShutdownDelay)
+	timer := time.NewTimer(l.option.ShutdownDelay)
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+		timer.Stop()
+	}
+
+	var wg sync.WaitGroup
+	for _, s := range l.servers {
+		wg.Add(1)
+		go func(s *server) {
+			defer wg.Done()
+			if err := s.shutdown(ctx); err != nil {
+				logs.Errorf("%s shutdown failed with err: %v", s.name, err)
+				return
+			}
+			logs.Infof("%s shutdown gracefully", s.name)
+		}(s)
+	}
+	wg.Wait()
+
+	for _, hook := range l.stops {
+		if err := hook(ctx); err != nil {
+			logs.Errorf("stop hook failed with err: %v", err)
+		}
+	}
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"log"
-	"strings"
 
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
@@ -18,12 +17,16 @@ const (
 )
 
 var (
-	_      pb.OrderManagementServer = &OrderManagementImpl{}
-	orders                          = make(map[string]pb.Order, 0)
+	_ pb.OrderManagementServer = &OrderManagementImpl{}
 )
 
 type OrderManagementImpl struct {
 	pb.UnimplementedOrderManagementServer
+	store OrderStore
+}
+
+func NewOrderManagementImpl(store OrderStore) *OrderManagementImpl {
+	return &OrderManagementImpl{store: store}
 }
 
 func (s *OrderManagementImpl) SayHello(ctx context.Context, hello *pb.Hello) (*wrapperspb.StringValue, error) {
@@ -34,7 +37,9 @@ func (s *OrderManagementImpl) SayHello(ctx context.Context, hello *pb.Hello) (*w
 func (s *OrderManagementImpl) AddOrder(ctx context.Context, orderReq *pb.Order) (*wrapperspb.StringValue, error) {
 	log.Println("AddOrder:")
 	log.Printf("Order Added. ID : %v", orderReq.Id)
-	orders[orderReq.Id] = *orderReq
+	if err := s.store.Put(orderReq); err != nil {
+		return nil, status.Errorf(codes.Internal, "Order not added: %v", err)
+	}
 	return &wrapperspb.StringValue{Value: "Order Added: " + orderReq.Id}, nil
 }
 
@@ -42,25 +47,21 @@ func (s *OrderManagementImpl) AddOrder(ctx context.Context, orderReq *pb.Order)
 func (s *OrderManagementImpl) GetOrder(ctx context.Context, orderId *wrapperspb.StringValue) (*pb.Order, error) {
 	log.Println("GetOrder:")
 	log.Printf("Order ID: %s", orderId.Value)
-	ord, exists := orders[orderId.Value]
+	ord, exists := s.store.Get(orderId.Value)
 	if exists {
-		return &ord, status.New(codes.OK, "").Err()
+		return ord, status.New(codes.OK, "").Err()
 	}
-	return nil, status.Errorf(codes.NotFound, "Order does not exist. : ", orderId)
+	return nil, status.Errorf(codes.NotFound, "Order does not exist. : %s", orderId.Value)
 }
 
 // SearchOrders Server-Streaming RPC
 func (s *OrderManagementImpl) SearchOrders(query *wrapperspb.StringValue, stream pb.OrderManagement_SearchOrdersServer) error {
 	log.Println("SearchOrders:")
 	log.Printf("Query Value : %s", query.Value)
-	for _, order := range orders {
-		for _, str := range order.Items {
-			if strings.Contains(str, query.Value) {
-				err := stream.Send(&order)
-				if err != nil {
-					return fmt.Errorf("error send: %v", err)
-				}
-			}
+	for _, order := range s.store.Search(query.Value) {
+		err := stream.Send(order)
+		if err != nil {
+			return fmt.Errorf("error send: %v", err)
 		}
 	}
 
@@ -80,8 +81,13 @@ func (s *OrderManagementImpl) UpdateOrders(stream pb.OrderManagement_UpdateOrder
 			return stream.SendAndClose(
 				&wrapperspb.StringValue{Value: "Orders processed " + ordersStr})
 		}
+		if err != nil {
+			return err
+		}
 		// Update order
-		orders[order.Id] = *order
+		if err := s.store.Put(order); err != nil {
+			return status.Errorf(codes.Internal, "Order not updated: %v", err)
+		}
 
 		log.Println("Order ID ", order.Id, ": Updated")
 		ordersStr += order.Id + ", "
@@ -92,12 +98,12 @@ func (s *OrderManagementImpl) UpdateOrders(stream pb.OrderManagement_UpdateOrder
 func (s *OrderManagementImpl) ProcessOrders(stream pb.OrderManagement_ProcessOrdersServer) error {
 	log.Println("ProcessOrders:")
 	batchMarker := 1
-	var combinedShipmentMap = make(map[string]pb.CombinedShipment)
+	var combinedShipmentMap = make(map[string]*pb.CombinedShipment)
 	for {
 		orderId, err := stream.Recv()
 		if err == io.EOF {
 			for _, shipment := range combinedShipmentMap {
-				if err := stream.Send(&shipment); err != nil {
+				if err := stream.Send(shipment); err != nil {
 					return err
 				}
 			}
@@ -108,17 +114,21 @@ func (s *OrderManagementImpl) ProcessOrders(stream pb.OrderManagement_ProcessOrd
 			return err
 		}
 
-		destination := orders[orderId.GetValue()].Addresses[0].Address
+		ord, exists := s.store.Get(orderId.GetValue())
+		if !exists {
+			return status.Errorf(codes.NotFound, "Order does not exist. : %s", orderId.GetValue())
+		}
+		if len(ord.Addresses) == 0 {
+			return status.Errorf(codes.InvalidArgument, "Order has no address. : %s", orderId.GetValue())
+		}
+		destination := ord.Addresses[0].Address
 		shipment, found := combinedShipmentMap[destination]
 
 		if found {
-			ord := orders[orderId.GetValue()]
-			shipment.OrderList = append(shipment.OrderList, &ord)
-			combinedShipmentMap[destination] = shipment
+			shipment.OrderList = append(shipment.OrderList, ord)
 		} else {
-			comShip := pb.CombinedShipment{Id: "cmb - " + (orders[orderId.GetValue()].Addresses[0].Address), Status: "Processed!"}
-			ord := orders[orderId.GetValue()]
-			comShip.OrderList = append(shipment.OrderList, &ord)
+			comShip := &pb.CombinedShipment{Id: "cmb - " + destination, Status: "Processed!"}
+			comShip.OrderList = append(comShip.OrderList, ord)
 			combinedShipmentMap[destination] = comShip
 			log.Print(len(comShip.OrderList), comShip.GetId())
 		}
@@ -126,12 +136,12 @@ func (s *OrderManagementImpl) ProcessOrders(stream pb.OrderManagement_ProcessOrd
 		if batchMarker == orderBatchSize {
 			for _, comb := range combinedShipmentMap {
 				log.Printf("Shipping : %v -> %v", comb.Id, len(comb.OrderList))
-				if err := stream.Send(&comb); err != nil {
+				if err := stream.Send(comb); err != nil {
 					return err
 				}
 			}
 			batchMarker = 0
-			combinedShipmentMap = make(map[string]pb.CombinedShipment)
+			combinedShipmentMap = make(map[string]*pb.CombinedShipment)
 		} else {
 			batchMarker++
 		}
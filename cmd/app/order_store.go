@@ -0,0 +1,159 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"httpbin/pkg/options"
+	pb "httpbin/pkg/order"
+)
+
+// OrderStore keeps orders of the OrderManagement service. Implementations must be
+// safe for concurrent use and must not share returned orders with callers.
+type OrderStore interface {
+	Get(id string) (*pb.Order, bool)
+	Put(order *pb.Order) error
+	// Search returns orders with an item containing query.
+	Search(query string) []*pb.Order
+}
+
+func NewOrderStore(option *options.Option) (OrderStore, error) {
+	switch option.OrderStore {
+	case "", options.OrderStoreMemory:
+		return NewMemoryOrderStore(), nil
+	case options.OrderStoreFile:
+		return NewFileOrderStore(option.OrderStoreFile)
+	default:
+		return nil, errors.New("not support order store")
+	}
+}
+
+type MemoryOrderStore struct {
+	mutex  sync.RWMutex
+	orders map[string]*pb.Order
+}
+
+func NewMemoryOrderStore() *MemoryOrderStore {
+	return &MemoryOrderStore{orders: make(map[string]*pb.Order)}
+}
+
+func (s *MemoryOrderStore) Get(id string) (*pb.Order, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	order, exists := s.orders[id]
+	if !exists {
+		return nil, false
+	}
+	return proto.Clone(order).(*pb.Order), true
+}
+
+func (s *MemoryOrderStore) Put(order *pb.Order) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.orders[order.Id] = proto.Clone(order).(*pb.Order)
+	return nil
+}
+
+func (s *MemoryOrderStore) Search(query string) []*pb.Order {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	result := make([]*pb.Order, 0)
+	for _, order := range s.orders {
+		for _, item := range order.Items {
+			if strings.Contains(item, query) {
+				result = append(result, proto.Clone(order).(*pb.Order))
+				break
+			}
+		}
+	}
+	return result
+}
+
+// FileOrderStore is a MemoryOrderStore that writes a JSON snapshot of all
+// orders to file on every change and loads it on start.
+type FileOrderStore struct {
+	*MemoryOrderStore
+	file string
+}
+
+func NewFileOrderStore(file string) (*FileOrderStore, error) {
+	s := &FileOrderStore{MemoryOrderStore: NewMemoryOrderStore(), file: file}
+	content, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var snapshot []json.RawMessage
+	if err := json.Unmarshal(content, &snapshot); err != nil {
+		return nil, err
+	}
+	for _, raw := range snapshot {
+		order := &pb.Order{}
+		if err := protojson.Unmarshal(raw, order); err != nil {
+			return nil, err
+		}
+		s.orders[order.Id] = order
+	}
+	return s, nil
+}
+
+// Put saves the orders with order first and keeps them only if the snapshot was
+// written, so a failed Put leaves the store as it was.
+func (s *FileOrderStore) Put(order *pb.Order) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	orders := make(map[string]*pb.Order, len(s.orders)+1)
+	for id, stored := range s.orders {
+		orders[id] = stored
+	}
+	orders[order.Id] = proto.Clone(order).(*pb.Order)
+	if err := s.save(orders); err != nil {
+		return err
+	}
+	s.orders = orders
+	return nil
+}
+
+// save writes the snapshot of orders to a temp file and renames it, so a crash
+// never leaves a partial snapshot. Caller must hold the lock.
+func (s *FileOrderStore) save(orders map[string]*pb.Order) error {
+	ids := make([]string, 0, len(orders))
+	for id := range orders {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	snapshot := make([]json.RawMessage, 0, len(ids))
+	for _, id := range ids {
+		raw, err := protojson.Marshal(orders[id])
+		if err != nil {
+			return err
+		}
+		snapshot = append(snapshot, raw)
+	}
+	content, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.file), filepath.Base(s.file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.file)
+}
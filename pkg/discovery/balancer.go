@@ -0,0 +1,74 @@
+package discovery
+
+import (
+	"errors"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+
+	"httpbin/pkg/options"
+)
+
+// Balancer picks one of endpoints of a service. done must be called when the
+// request to the picked endpoint finishes.
+type Balancer interface {
+	Pick(service string, endpoints []Endpoint) (endpoint Endpoint, done func())
+}
+
+func BalancerFactory(name string) (Balancer, error) {
+	switch name {
+	case "", options.LoadBalancerRoundRobin:
+		return &roundRobinBalancer{}, nil
+	case options.LoadBalancerRandom:
+		return &randomBalancer{}, nil
+	case options.LoadBalancerLeastRequest:
+		return &leastRequestBalancer{inflight: make(map[string]int64)}, nil
+	default:
+		return nil, errors.New("not support load balancer")
+	}
+}
+
+type roundRobinBalancer struct {
+	counters sync.Map
+}
+
+func (b *roundRobinBalancer) Pick(service string, endpoints []Endpoint) (Endpoint, func()) {
+	value, _ := b.counters.LoadOrStore(service, new(uint64))
+	next := atomic.AddUint64(value.(*uint64), 1)
+	return endpoints[(next-1)%uint64(len(endpoints))], func() {}
+}
+
+type randomBalancer struct{}
+
+func (b *randomBalancer) Pick(service string, endpoints []Endpoint) (Endpoint, func()) {
+	return endpoints[rand.Intn(len(endpoints))], func() {}
+}
+
+// leastRequestBalancer picks the endpoint with the fewest in-flight requests,
+// breaking ties randomly.
+type leastRequestBalancer struct {
+	mutex    sync.Mutex
+	inflight map[string]int64
+}
+
+func (b *leastRequestBalancer) Pick(service string, endpoints []Endpoint) (Endpoint, func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	offset := rand.Intn(len(endpoints))
+	picked := endpoints[offset]
+	for i := 1; i < len(endpoints); i++ {
+		endpoint := endpoints[(offset+i)%len(endpoints)]
+		if b.inflight[endpoint.Address()] < b.inflight[picked.Address()] {
+			picked = endpoint
+		}
+	}
+	address := picked.Address()
+	b.inflight[address]++
+	return picked, func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		if b.inflight[address]--; b.inflight[address] <= 0 {
+			delete(b.inflight, address)
+		}
+	}
+}
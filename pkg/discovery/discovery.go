@@ -0,0 +1,110 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"httpbin/pkg/options"
+	"httpbin/pkg/registry"
+)
+
+type Endpoint struct {
+	Host     string
+	Port     int
+	Protocol string
+	Meta     map[string]string
+}
+
+func (e Endpoint) protocol() string {
+	if len(e.Protocol) == 0 {
+		return string(registry.Http)
+	}
+	return e.Protocol
+}
+
+// Address returns host:port, or host only when port is unknown.
+func (e Endpoint) Address() string {
+	if e.Port == 0 {
+		return e.Host
+	}
+	return net.JoinHostPort(e.Host, strconv.Itoa(e.Port))
+}
+
+// Discovery lists the endpoints of a service. protocol lets a discovery look up the
+// endpoints of one protocol only, the resolver still picks endpoints by Endpoint.Protocol.
+type Discovery interface {
+	Endpoints(ctx context.Context, service string, protocol string) ([]Endpoint, error)
+}
+
+// Resolver picks one endpoint of a service using the configured discovery and balancer.
+type Resolver struct {
+	discovery Discovery
+	balancer  Balancer
+}
+
+// Resolve returns an endpoint of service speaking protocol, and a done func the
+// caller must call when the request to it finishes. Endpoints without protocol
+// are http endpoints. Without discovery the service name is used as is.
+func (r *Resolver) Resolve(ctx context.Context, service string, protocol string) (Endpoint, func(), error) {
+	if r == nil || r.discovery == nil {
+		return Endpoint{Host: service}, func() {}, nil
+	}
+	endpoints, err := r.discovery.Endpoints(ctx, service, protocol)
+	if err != nil {
+		return Endpoint{}, nil, err
+	}
+	matched := make([]Endpoint, 0, len(endpoints))
+	for _, endpoint := range endpoints {
+		if endpoint.protocol() == protocol {
+			matched = append(matched, endpoint)
+		}
+	}
+	if len(matched) == 0 {
+		return Endpoint{}, nil, fmt.Errorf("no %s endpoints of service %s", protocol, service)
+	}
+	endpoint, done := r.balancer.Pick(service, matched)
+	return endpoint, done, nil
+}
+
+func DiscoveryFactory(option *options.Option, serviceRegistry registry.ServiceRegistry) (Discovery, error) {
+	switch option.DiscoveryType {
+	case options.DiscoveryTypeStatic:
+		return NewStaticDiscovery(option.DiscoveryFile)
+	case options.DiscoveryTypeDns:
+		return NewDnsDiscovery(option.DiscoveryDnsService, option.DiscoveryDnsDomain), nil
+	case options.DiscoveryTypeRegistry:
+		if serviceRegistry == nil {
+			var err error
+			if serviceRegistry, err = registry.ServiceRegistryFactory(option); err != nil {
+				return nil, err
+			}
+		}
+		serviceDiscovery, ok := serviceRegistry.(registry.ServiceDiscovery)
+		if !ok {
+			return nil, errors.New("registry does not support discovery")
+		}
+		return NewRegistryDiscovery(serviceDiscovery), nil
+	default:
+		return nil, errors.New("not support discovery type")
+	}
+}
+
+// NewResolver returns a resolver for next hops of /service. serviceRegistry is
+// reused for registry discovery when registration is enabled, and may be nil.
+func NewResolver(option *options.Option, serviceRegistry registry.ServiceRegistry) (*Resolver, error) {
+	if len(option.DiscoveryType) == 0 || option.DiscoveryType == options.DiscoveryTypeNone {
+		return nil, nil
+	}
+	discovery, err := DiscoveryFactory(option, serviceRegistry)
+	if err != nil {
+		return nil, err
+	}
+	balancer, err := BalancerFactory(option.LoadBalancer)
+	if err != nil {
+		return nil, err
+	}
+	return &Resolver{discovery: discovery, balancer: balancer}, nil
+}
@@ -0,0 +1,45 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"httpbin/pkg/registry"
+)
+
+// DnsDiscovery resolves endpoints from DNS SRV records _service._tcp.name[.domain]. The
+// SRV service of http endpoints is configured, https, grpc and grpcs endpoints are looked
+// up under their protocol, e.g. _grpc._tcp.backend.
+type DnsDiscovery struct {
+	service string
+	domain  string
+}
+
+func (d *DnsDiscovery) Endpoints(ctx context.Context, service string, protocol string) ([]Endpoint, error) {
+	name := service
+	if len(d.domain) > 0 {
+		name = service + "." + d.domain
+	}
+	srvService := protocol
+	if protocol == string(registry.Http) {
+		srvService = d.service
+	}
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, srvService, "tcp", name)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make([]Endpoint, 0, len(records))
+	for _, record := range records {
+		endpoints = append(endpoints, Endpoint{
+			Host:     strings.TrimSuffix(record.Target, "."),
+			Port:     int(record.Port),
+			Protocol: protocol,
+		})
+	}
+	return endpoints, nil
+}
+
+func NewDnsDiscovery(service string, domain string) *DnsDiscovery {
+	return &DnsDiscovery{service: service, domain: domain}
+}
@@ -0,0 +1,35 @@
+package discovery
+
+import (
+	"context"
+	"strconv"
+
+	"httpbin/pkg/registry"
+)
+
+// RegistryDiscovery resolves endpoints from instances registered in Consul, Nacos or etcd.
+type RegistryDiscovery struct {
+	serviceDiscovery registry.ServiceDiscovery
+}
+
+func (d *RegistryDiscovery) Endpoints(ctx context.Context, service string, protocol string) ([]Endpoint, error) {
+	instances, err := d.serviceDiscovery.GetInstances(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make([]Endpoint, 0, len(instances))
+	for _, instance := range instances {
+		port, _ := strconv.Atoi(instance.Port)
+		endpoints = append(endpoints, Endpoint{
+			Host:     instance.Ip,
+			Port:     port,
+			Protocol: string(instance.Protocol),
+			Meta:     instance.ServiceMeta,
+		})
+	}
+	return endpoints, nil
+}
+
+func NewRegistryDiscovery(serviceDiscovery registry.ServiceDiscovery) *RegistryDiscovery {
+	return &RegistryDiscovery{serviceDiscovery: serviceDiscovery}
+}
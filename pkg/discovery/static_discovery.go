@@ -0,0 +1,71 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// StaticDiscovery reads endpoints from a yaml file mapping service name to
+// host:port endpoints, optionally prefixed with the protocol, e.g.
+//
+//	backend:
+//	  - 10.0.0.1:80
+//	  - grpc://10.0.0.1:9091
+//
+// Endpoints without protocol are http endpoints.
+type StaticDiscovery struct {
+	endpoints map[string][]Endpoint
+}
+
+func (d *StaticDiscovery) Endpoints(ctx context.Context, service string, protocol string) ([]Endpoint, error) {
+	endpoints, ok := d.endpoints[service]
+	if !ok {
+		return nil, fmt.Errorf("service %s not found in static discovery", service)
+	}
+	return endpoints, nil
+}
+
+func NewStaticDiscovery(file string) (*StaticDiscovery, error) {
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	services := make(map[string][]string)
+	if err := yaml.Unmarshal(content, &services); err != nil {
+		return nil, err
+	}
+	d := &StaticDiscovery{endpoints: make(map[string][]Endpoint, len(services))}
+	for service, addresses := range services {
+		for _, address := range addresses {
+			endpoint, err := parseEndpoint(address)
+			if err != nil {
+				return nil, fmt.Errorf("service %s: %v", service, err)
+			}
+			d.endpoints[service] = append(d.endpoints[service], endpoint)
+		}
+	}
+	return d, nil
+}
+
+func parseEndpoint(address string) (Endpoint, error) {
+	endpoint := Endpoint{}
+	if protocol, rest, ok := strings.Cut(address, "://"); ok {
+		endpoint.Protocol = protocol
+		address = rest
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return Endpoint{}, err
+	}
+	endpoint.Host = host
+	if endpoint.Port, err = strconv.Atoi(port); err != nil {
+		return Endpoint{}, err
+	}
+	return endpoint, nil
+}
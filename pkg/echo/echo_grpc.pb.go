@@ -0,0 +1,436 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.17.3
+// source: echo.proto
+
+package echo
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// EchoClient is the client API for Echo service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type EchoClient interface {
+	// peer returns the caller address and tls identity as seen by httpbin, e.g. to verify
+	// mesh mTLS end to end.
+	Peer(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*PeerInfo, error)
+	Unary(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (*EchoResponse, error)
+	// serverStream sends count responses to the request.
+	ServerStream(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (Echo_ServerStreamClient, error)
+	// clientStream responds once all requests are received, joining their messages and
+	// applying the parameters of the last request.
+	ClientStream(ctx context.Context, opts ...grpc.CallOption) (Echo_ClientStreamClient, error)
+	// bidiStream responds to every request, ending the stream with the first code.
+	BidiStream(ctx context.Context, opts ...grpc.CallOption) (Echo_BidiStreamClient, error)
+	// service continues the /service call chain on the grpc side, calling the next hop or
+	// returning the JSON response of / as the last hop.
+	Service(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*ServiceResponse, error)
+	// serviceGraph calls the next hops of the graph in parallel, returning the call tree as
+	// the JSON body, or as the error message when a hop failed.
+	ServiceGraph(ctx context.Context, in *ServiceGraphRequest, opts ...grpc.CallOption) (*ServiceResponse, error)
+}
+
+type echoClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEchoClient(cc grpc.ClientConnInterface) EchoClient {
+	return &echoClient{cc}
+}
+
+func (c *echoClient) Peer(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*PeerInfo, error) {
+	out := new(PeerInfo)
+	err := c.cc.Invoke(ctx, "/httpbin.Echo/peer", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *echoClient) Unary(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (*EchoResponse, error) {
+	out := new(EchoResponse)
+	err := c.cc.Invoke(ctx, "/httpbin.Echo/unary", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *echoClient) ServerStream(ctx context.Context, in *EchoRequest, opts ...grpc.CallOption) (Echo_ServerStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Echo_ServiceDesc.Streams[0], "/httpbin.Echo/serverStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &echoServerStreamClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Echo_ServerStreamClient interface {
+	Recv() (*EchoResponse, error)
+	grpc.ClientStream
+}
+
+type echoServerStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoServerStreamClient) Recv() (*EchoResponse, error) {
+	m := new(EchoResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *echoClient) ClientStream(ctx context.Context, opts ...grpc.CallOption) (Echo_ClientStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Echo_ServiceDesc.Streams[1], "/httpbin.Echo/clientStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &echoClientStreamClient{stream}
+	return x, nil
+}
+
+type Echo_ClientStreamClient interface {
+	Send(*EchoRequest) error
+	CloseAndRecv() (*EchoResponse, error)
+	grpc.ClientStream
+}
+
+type echoClientStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoClientStreamClient) Send(m *EchoRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *echoClientStreamClient) CloseAndRecv() (*EchoResponse, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(EchoResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *echoClient) BidiStream(ctx context.Context, opts ...grpc.CallOption) (Echo_BidiStreamClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Echo_ServiceDesc.Streams[2], "/httpbin.Echo/bidiStream", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &echoBidiStreamClient{stream}
+	return x, nil
+}
+
+type Echo_BidiStreamClient interface {
+	Send(*EchoRequest) error
+	Recv() (*EchoResponse, error)
+	grpc.ClientStream
+}
+
+type echoBidiStreamClient struct {
+	grpc.ClientStream
+}
+
+func (x *echoBidiStreamClient) Send(m *EchoRequest) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *echoBidiStreamClient) Recv() (*EchoResponse, error) {
+	m := new(EchoResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *echoClient) Service(ctx context.Context, in *ServiceRequest, opts ...grpc.CallOption) (*ServiceResponse, error) {
+	out := new(ServiceResponse)
+	err := c.cc.Invoke(ctx, "/httpbin.Echo/service", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *echoClient) ServiceGraph(ctx context.Context, in *ServiceGraphRequest, opts ...grpc.CallOption) (*ServiceResponse, error) {
+	out := new(ServiceResponse)
+	err := c.cc.Invoke(ctx, "/httpbin.Echo/serviceGraph", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// EchoServer is the server API for Echo service.
+// All implementations must embed UnimplementedEchoServer
+// for forward compatibility
+type EchoServer interface {
+	// peer returns the caller address and tls identity as seen by httpbin, e.g. to verify
+	// mesh mTLS end to end.
+	Peer(context.Context, *emptypb.Empty) (*PeerInfo, error)
+	Unary(context.Context, *EchoRequest) (*EchoResponse, error)
+	// serverStream sends count responses to the request.
+	ServerStream(*EchoRequest, Echo_ServerStreamServer) error
+	// clientStream responds once all requests are received, joining their messages and
+	// applying the parameters of the last request.
+	ClientStream(Echo_ClientStreamServer) error
+	// bidiStream responds to every request, ending the stream with the first code.
+	BidiStream(Echo_BidiStreamServer) error
+	// service continues the /service call chain on the grpc side, calling the next hop or
+	// returning the JSON response of / as the last hop.
+	Service(context.Context, *ServiceRequest) (*ServiceResponse, error)
+	// serviceGraph calls the next hops of the graph in parallel, returning the call tree as
+	// the JSON body, or as the error message when a hop failed.
+	ServiceGraph(context.Context, *ServiceGraphRequest) (*ServiceResponse, error)
+	mustEmbedUnimplementedEchoServer()
+}
+
+// UnimplementedEchoServer must be embedded to have forward compatible implementations.
+type UnimplementedEchoServer struct {
+}
+
+func (UnimplementedEchoServer) Peer(context.Context, *emptypb.Empty) (*PeerInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Peer not implemented")
+}
+func (UnimplementedEchoServer) Unary(context.Context, *EchoRequest) (*EchoResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Unary not implemented")
+}
+func (UnimplementedEchoServer) ServerStream(*EchoRequest, Echo_ServerStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method ServerStream not implemented")
+}
+func (UnimplementedEchoServer) ClientStream(Echo_ClientStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method ClientStream not implemented")
+}
+func (UnimplementedEchoServer) BidiStream(Echo_BidiStreamServer) error {
+	return status.Errorf(codes.Unimplemented, "method BidiStream not implemented")
+}
+func (UnimplementedEchoServer) Service(context.Context, *ServiceRequest) (*ServiceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Service not implemented")
+}
+func (UnimplementedEchoServer) ServiceGraph(context.Context, *ServiceGraphRequest) (*ServiceResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ServiceGraph not implemented")
+}
+func (UnimplementedEchoServer) mustEmbedUnimplementedEchoServer() {}
+
+// UnsafeEchoServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EchoServer will
+// result in compilation errors.
+type UnsafeEchoServer interface {
+	mustEmbedUnimplementedEchoServer()
+}
+
+func RegisterEchoServer(s grpc.ServiceRegistrar, srv EchoServer) {
+	s.RegisterService(&Echo_ServiceDesc, srv)
+}
+
+func _Echo_Peer_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoServer).Peer(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/httpbin.Echo/peer",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoServer).Peer(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Echo_Unary_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EchoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoServer).Unary(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/httpbin.Echo/unary",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoServer).Unary(ctx, req.(*EchoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Echo_ServerStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(EchoRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EchoServer).ServerStream(m, &echoServerStreamServer{stream})
+}
+
+type Echo_ServerStreamServer interface {
+	Send(*EchoResponse) error
+	grpc.ServerStream
+}
+
+type echoServerStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoServerStreamServer) Send(m *EchoResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Echo_ClientStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EchoServer).ClientStream(&echoClientStreamServer{stream})
+}
+
+type Echo_ClientStreamServer interface {
+	SendAndClose(*EchoResponse) error
+	Recv() (*EchoRequest, error)
+	grpc.ServerStream
+}
+
+type echoClientStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoClientStreamServer) SendAndClose(m *EchoResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *echoClientStreamServer) Recv() (*EchoRequest, error) {
+	m := new(EchoRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Echo_BidiStream_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(EchoServer).BidiStream(&echoBidiStreamServer{stream})
+}
+
+type Echo_BidiStreamServer interface {
+	Send(*EchoResponse) error
+	Recv() (*EchoRequest, error)
+	grpc.ServerStream
+}
+
+type echoBidiStreamServer struct {
+	grpc.ServerStream
+}
+
+func (x *echoBidiStreamServer) Send(m *EchoResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *echoBidiStreamServer) Recv() (*EchoRequest, error) {
+	m := new(EchoRequest)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Echo_Service_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServiceRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoServer).Service(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/httpbin.Echo/service",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoServer).Service(ctx, req.(*ServiceRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Echo_ServiceGraph_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ServiceGraphRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(EchoServer).ServiceGraph(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/httpbin.Echo/serviceGraph",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(EchoServer).ServiceGraph(ctx, req.(*ServiceGraphRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Echo_ServiceDesc is the grpc.ServiceDesc for Echo service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Echo_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "httpbin.Echo",
+	HandlerType: (*EchoServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "peer",
+			Handler:    _Echo_Peer_Handler,
+		},
+		{
+			MethodName: "unary",
+			Handler:    _Echo_Unary_Handler,
+		},
+		{
+			MethodName: "service",
+			Handler:    _Echo_Service_Handler,
+		},
+		{
+			MethodName: "serviceGraph",
+			Handler:    _Echo_ServiceGraph_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "serverStream",
+			Handler:       _Echo_ServerStream_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "clientStream",
+			Handler:       _Echo_ClientStream_Handler,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "bidiStream",
+			Handler:       _Echo_BidiStream_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "echo.proto",
+}
@@ -0,0 +1,301 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"httpbin/pkg/logs"
+	"httpbin/pkg/options"
+	"httpbin/pkg/tracing"
+)
+
+const skipHealthPrefix = "/grpc.health.v1.Health/"
+
+// isHealthCheck reports whether method is a health check, which is polled by probes and
+// load balancers and left out of the spans, metrics and access logs.
+func isHealthCheck(method string) bool {
+	return strings.HasPrefix(method, skipHealthPrefix)
+}
+
+// GrpcServerOptions returns the interceptors for tracing, metrics, access logs, fault
+// injection and panic recovery of the grpc server, the grpc counterpart of the gin
+// middlewares. Tracing starts the server spans of tracer, connection reset faults reset
+// the connections tracked by conns.
+func GrpcServerOptions(option *options.Option, tracer tracing.Tracer, conns *GrpcFaultConns) []grpc.ServerOption {
+	unary, stream := grpcInterceptors(tracer, conns)
+	return []grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(unary...),
+		grpc.ChainStreamInterceptor(stream...),
+	}
+}
+
+// GrpcUnaryInterceptor chains the fault injection and panic recovery unary interceptors
+// into one, for grpc handlers called outside the grpc server like the REST/JSON
+// transcoding. Their routes are already traced, counted and logged by the gin middlewares.
+func GrpcUnaryInterceptor(option *options.Option) grpc.UnaryServerInterceptor {
+	interceptors, _ := grpcHandlerInterceptors(nil)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+		return handler(ctx, req)
+	}
+}
+
+// GrpcStreamInterceptor is the stream counterpart of GrpcUnaryInterceptor.
+func GrpcStreamInterceptor(option *options.Option) grpc.StreamServerInterceptor {
+	_, interceptors := grpcHandlerInterceptors(nil)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(srv interface{}, ss grpc.ServerStream) error {
+				return interceptor(srv, ss, info, next)
+			}
+		}
+		return handler(srv, ss)
+	}
+}
+
+func grpcInterceptors(tracer tracing.Tracer, conns *GrpcFaultConns) ([]grpc.UnaryServerInterceptor, []grpc.StreamServerInterceptor) {
+	logger := logs.Logger()
+	unary := []grpc.UnaryServerInterceptor{
+		unaryTraceInterceptor(tracer),
+		unaryMetricInterceptor(),
+		unaryLogInterceptor(logger),
+	}
+	stream := []grpc.StreamServerInterceptor{
+		streamTraceInterceptor(tracer),
+		streamMetricInterceptor(),
+		streamLogInterceptor(logger),
+	}
+	handlerUnary, handlerStream := grpcHandlerInterceptors(conns)
+	return append(unary, handlerUnary...), append(stream, handlerStream...)
+}
+
+// grpcHandlerInterceptors returns the fault injection and panic recovery interceptors,
+// which run closest to the handler.
+func grpcHandlerInterceptors(conns *GrpcFaultConns) ([]grpc.UnaryServerInterceptor, []grpc.StreamServerInterceptor) {
+	logger := logs.Logger()
+	unary := []grpc.UnaryServerInterceptor{
+		unaryFaultInterceptor(conns),
+		unaryRecoveryInterceptor(logger),
+	}
+	stream := []grpc.StreamServerInterceptor{
+		streamFaultInterceptor(conns),
+		streamRecoveryInterceptor(logger),
+	}
+	return unary, stream
+}
+
+// contextServerStream overrides the context of a grpc.ServerStream, e.g. with the span.
+type contextServerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *contextServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func unaryTraceInterceptor(tracer tracing.Tracer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, finish := startGrpcSpan(ctx, tracer, info.FullMethod)
+		resp, err := handler(ctx, req)
+		finish(err)
+		return resp, err
+	}
+}
+
+func streamTraceInterceptor(tracer tracing.Tracer) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, finish := startGrpcSpan(ss.Context(), tracer, info.FullMethod)
+		err := handler(srv, &contextServerStream{ServerStream: ss, ctx: ctx})
+		finish(err)
+		return err
+	}
+}
+
+// startGrpcSpan starts the entry span of tracer continuing the trace from the incoming
+// metadata, finish ends it with the grpc status of err.
+func startGrpcSpan(ctx context.Context, tracer tracing.Tracer, method string) (context.Context, func(err error)) {
+	if isHealthCheck(method) {
+		return ctx, func(error) {}
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	ctx, span := tracer.StartGrpcServerSpan(ctx, method, md)
+	if p, ok := peer.FromContext(ctx); ok {
+		span.Tag(tracing.TagPeerAddress, p.Addr.String())
+	}
+	return ctx, func(err error) {
+		st := status.Convert(err)
+		span.Tag(tracing.TagGRPCStatusCode, st.Code().String())
+		if st.Code() != codes.OK {
+			span.Error(st.Message())
+		}
+		span.End()
+	}
+}
+
+func unaryMetricInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if isHealthCheck(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		observeGrpc(info.FullMethod, grpcTypeUnary, start, err)
+		return resp, err
+	}
+}
+
+func streamMetricInterceptor() grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isHealthCheck(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		start := time.Now()
+		err := handler(srv, &metricServerStream{ServerStream: ss, method: info.FullMethod})
+		observeGrpc(info.FullMethod, grpcStreamType(info), start, err)
+		return err
+	}
+}
+
+const (
+	grpcTypeUnary        = "unary"
+	grpcTypeClientStream = "client_stream"
+	grpcTypeServerStream = "server_stream"
+	grpcTypeBidiStream   = "bidi_stream"
+)
+
+func grpcStreamType(info *grpc.StreamServerInfo) string {
+	switch {
+	case info.IsClientStream && info.IsServerStream:
+		return grpcTypeBidiStream
+	case info.IsClientStream:
+		return grpcTypeClientStream
+	}
+	return grpcTypeServerStream
+}
+
+func observeGrpc(method string, grpcType string, start time.Time, err error) {
+	code := status.Code(err).String()
+	if counter, ok := grpcReqCountMetric.MetricCollector.(*prometheus.CounterVec); ok {
+		counter.WithLabelValues(code, method, grpcType).Inc()
+	}
+	if histogram, ok := grpcReqDurationMetric.MetricCollector.(*prometheus.HistogramVec); ok {
+		histogram.WithLabelValues(code, method, grpcType).Observe(time.Since(start).Seconds())
+	}
+}
+
+// metricServerStream counts the stream messages.
+type metricServerStream struct {
+	grpc.ServerStream
+	method string
+}
+
+func (s *metricServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if counter, ok := grpcMsgSentMetric.MetricCollector.(*prometheus.CounterVec); ok && err == nil {
+		counter.WithLabelValues(s.method).Inc()
+	}
+	return err
+}
+
+func (s *metricServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if counter, ok := grpcMsgReceivedMetric.MetricCollector.(*prometheus.CounterVec); ok && err == nil {
+		counter.WithLabelValues(s.method).Inc()
+	}
+	return err
+}
+
+func unaryLogInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if isHealthCheck(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		start := time.Now()
+		resp, err := handler(ctx, req)
+		logGrpc(logger, ctx, info.FullMethod, grpcTypeUnary, start, err)
+		return resp, err
+	}
+}
+
+func streamLogInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isHealthCheck(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		start := time.Now()
+		err := handler(srv, ss)
+		logGrpc(logger, ss.Context(), info.FullMethod, grpcStreamType(info), start, err)
+		return err
+	}
+}
+
+func logGrpc(logger *zap.Logger, ctx context.Context, method string, grpcType string, start time.Time, err error) {
+	st := status.Convert(err)
+	fields := []zap.Field{
+		zap.String("code", st.Code().String()),
+		zap.String("method", method),
+		zap.String("type", grpcType),
+		zap.Duration("latency", time.Since(start)),
+		zap.String("time", time.Now().UTC().Format(time.RFC3339)),
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		fields = append(fields, zap.String("ip", p.Addr.String()))
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		fields = append(fields, zap.String("user-agent", strings.Join(md.Get("user-agent"), " ")))
+	}
+	if err != nil {
+		logger.Warn(st.Message(), fields...)
+		return
+	}
+	logger.Info(method, fields...)
+}
+
+func unaryRecoveryInterceptor(logger *zap.Logger) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recoverGrpc(logger, info.FullMethod, r)
+			}
+		}()
+		return handler(ctx, req)
+	}
+}
+
+func streamRecoveryInterceptor(logger *zap.Logger) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = recoverGrpc(logger, info.FullMethod, r)
+			}
+		}()
+		return handler(srv, ss)
+	}
+}
+
+func recoverGrpc(logger *zap.Logger, method string, r interface{}) error {
+	logger.Error("[Recovery from panic]",
+		zap.Time("time", time.Now()),
+		zap.Any("error", r),
+		zap.String("method", method),
+		zap.String("stack", string(debug.Stack())),
+	)
+	return status.Error(codes.Internal, fmt.Sprintf("panic: %v", r))
+}
@@ -0,0 +1,467 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// Request metadata injecting faults into any grpc call, e.g.
+//
+//	grpcurl -H 'x-httpbin-fault-code: UNAVAILABLE' -H 'x-httpbin-fault-percentage: 50' ...
+const (
+	// Status code to return, a number or name like UNAVAILABLE.
+	FaultCode = "x-httpbin-fault-code"
+	// Percentage of calls to inject the faults into, default 100.
+	FaultPercentage = "x-httpbin-fault-percentage"
+	// Delay before handling the call, a duration like 1s or "deadline" to wait until the deadline.
+	FaultDelay = "x-httpbin-fault-delay"
+	// Abort a stream after N responses, or N requests of a client stream, with FaultCode or ABORTED.
+	FaultAbortAfter = "x-httpbin-fault-abort-after"
+	// Pad the first string or bytes field of every response to N bytes.
+	FaultOversize = "x-httpbin-fault-oversize"
+	// Abort by resetting the whole connection with a TCP RST instead of returning a status,
+	// every other stream of the connection fails too. Connections are found by the remote
+	// address of the call, so behind a proxy sharing one upstream connection between its
+	// clients this resets the traffic of all clients of the proxy.
+	FaultResetConnection = "x-httpbin-fault-reset-connection"
+	// Reset the HTTP/2 stream of the call with RST_STREAM INTERNAL_ERROR before it is handled,
+	// other streams of the connection are not affected. Only served with --grpc-stream-reset,
+	// the grpc transport cannot reset a single stream.
+	FaultResetStream = "x-httpbin-fault-reset-stream"
+
+	faultDelayDeadline = "deadline"
+)
+
+// Values of the fault label of grpc_faults_total.
+const (
+	faultTypeDelay    = "delay"
+	faultTypeAbort    = "abort"
+	faultTypeReset    = "reset_connection"
+	faultTypeStream   = "reset_stream"
+	faultTypeOversize = "oversize"
+)
+
+type grpcFault struct {
+	code       codes.Code
+	delay      time.Duration
+	untilDone  bool
+	abortAfter int
+	oversize   int
+	reset      bool
+	conns      *GrpcFaultConns
+}
+
+// Health checks and reflection are never faulted, so probes and tools keep working while
+// the faults are injected by a proxy into all calls.
+const skipReflectionPrefix = "/grpc.reflection."
+
+func skipFault(method string) bool {
+	return isHealthCheck(method) || strings.HasPrefix(method, skipReflectionPrefix)
+}
+
+// parseGrpcFault returns the faults requested by the incoming metadata, or nil if there
+// are none or the call is not selected by the percentage. A connection reset resets the
+// connection in conns.
+func parseGrpcFault(ctx context.Context, conns *GrpcFaultConns) (*grpcFault, error) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return nil, nil
+	}
+	get := func(key string) string {
+		if values := md.Get(key); len(values) > 0 {
+			return strings.TrimSpace(values[0])
+		}
+		return ""
+	}
+	fault := &grpcFault{code: codes.OK, abortAfter: -1, conns: conns}
+	requested := false
+	var err error
+	if v := get(FaultCode); len(v) > 0 {
+		if fault.code, err = parseCode(v); err != nil {
+			return nil, err
+		}
+		requested = true
+	}
+	if v := get(FaultDelay); len(v) > 0 {
+		if v == faultDelayDeadline {
+			fault.untilDone = true
+		} else if fault.delay, err = time.ParseDuration(v); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %v", FaultDelay, err)
+		}
+		requested = true
+	}
+	if v := get(FaultAbortAfter); len(v) > 0 {
+		if fault.abortAfter, err = strconv.Atoi(v); err != nil || fault.abortAfter < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s", FaultAbortAfter, v)
+		}
+		requested = true
+	}
+	if v := get(FaultOversize); len(v) > 0 {
+		if fault.oversize, err = strconv.Atoi(v); err != nil || fault.oversize < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s", FaultOversize, v)
+		}
+		requested = true
+	}
+	if v := get(FaultResetStream); len(v) > 0 {
+		// GrpcStreamResetHandler removes the header of the calls it serves.
+		if reset, err := strconv.ParseBool(v); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s", FaultResetStream, v)
+		} else if reset {
+			return nil, status.Errorf(codes.FailedPrecondition, "%s requires --grpc-stream-reset", FaultResetStream)
+		}
+	}
+	if v := get(FaultResetConnection); len(v) > 0 {
+		if fault.reset, err = strconv.ParseBool(v); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid %s: %s", FaultResetConnection, v)
+		}
+		requested = requested || fault.reset
+	}
+	if !requested {
+		return nil, nil
+	}
+	if selected, err := selectedByPercentage(get(FaultPercentage)); err != nil || !selected {
+		return nil, err
+	}
+	return fault, nil
+}
+
+// selectedByPercentage reports whether a call is selected by the FaultPercentage value v,
+// every call is selected without one.
+func selectedByPercentage(v string) (bool, error) {
+	if len(v) == 0 {
+		return true, nil
+	}
+	percentage, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return false, status.Errorf(codes.InvalidArgument, "invalid %s: %s", FaultPercentage, v)
+	}
+	return rand.Float64()*100 < percentage, nil
+}
+
+// GrpcStreamResetHandler wraps a grpc server served by the Go http2 server, resetting the
+// stream of the calls selected by FaultResetStream and FaultPercentage. The header is
+// removed from the calls it lets through, so the interceptors reject it only when grpc is
+// served without this handler.
+func GrpcStreamResetHandler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := strings.TrimSpace(r.Header.Get(FaultResetStream))
+		if len(v) == 0 || skipFault(r.URL.Path) {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		r.Header.Del(FaultResetStream)
+		reset, err := strconv.ParseBool(v)
+		if err != nil {
+			writeGrpcError(w, status.Errorf(codes.InvalidArgument, "invalid %s: %s", FaultResetStream, v))
+			return
+		}
+		selected, err := selectedByPercentage(strings.TrimSpace(r.Header.Get(FaultPercentage)))
+		if err != nil {
+			writeGrpcError(w, err)
+			return
+		}
+		if reset && selected {
+			countFault(r.URL.Path, faultTypeStream)
+			// The http2 server resets the stream of an aborted handler with INTERNAL_ERROR.
+			panic(http.ErrAbortHandler)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// writeGrpcError writes err as a trailers-only grpc response.
+func writeGrpcError(w http.ResponseWriter, err error) {
+	s := status.Convert(err)
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(int(s.Code())))
+	w.Header().Set("Grpc-Message", encodeGrpcMessage(s.Message()))
+	w.WriteHeader(http.StatusOK)
+}
+
+// encodeGrpcMessage percent encodes the non printable ascii bytes and % of a grpc-message.
+func encodeGrpcMessage(msg string) string {
+	var b strings.Builder
+	for i := 0; i < len(msg); i++ {
+		if c := msg[i]; c < ' ' || c > '~' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+func parseCode(v string) (codes.Code, error) {
+	var code codes.Code
+	if n, err := strconv.Atoi(v); err == nil {
+		return codes.Code(n), nil
+	}
+	if err := code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(v)))); err != nil {
+		return codes.OK, status.Errorf(codes.InvalidArgument, "invalid %s: %s", FaultCode, v)
+	}
+	return code, nil
+}
+
+// before runs the faults applied before the handler, returning a non nil error if the
+// call ends without calling the handler.
+func (f *grpcFault) before(ctx context.Context, method string) error {
+	if f.untilDone || f.delay > 0 {
+		countFault(method, faultTypeDelay)
+		if err := f.sleep(ctx); err != nil {
+			return err
+		}
+	}
+	if f.abortAfter >= 0 {
+		// Aborted by the stream after abortAfter messages.
+		return nil
+	}
+	if f.reset || f.code != codes.OK {
+		return f.abort(ctx, method)
+	}
+	return nil
+}
+
+func (f *grpcFault) sleep(ctx context.Context) error {
+	if f.untilDone {
+		<-ctx.Done()
+		return status.FromContextError(ctx.Err()).Err()
+	}
+	timer := time.NewTimer(f.delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// abort resets the connection of the call or returns the fault code.
+func (f *grpcFault) abort(ctx context.Context, method string) error {
+	if f.reset {
+		countFault(method, faultTypeReset)
+		if p, ok := peer.FromContext(ctx); ok {
+			f.conns.reset(p.Addr.String())
+		}
+		return status.Error(codes.Unavailable, "connection reset by fault injection")
+	}
+	countFault(method, faultTypeAbort)
+	code := f.code
+	if code == codes.OK {
+		code = codes.Aborted
+	}
+	return status.Errorf(code, "fault injection %s", code)
+}
+
+// pad sets the first string or bytes field of m to oversize bytes.
+func (f *grpcFault) pad(m interface{}, method string) {
+	if f.oversize <= 0 {
+		return
+	}
+	msg, ok := m.(proto.Message)
+	if !ok {
+		return
+	}
+	r := msg.ProtoReflect()
+	fields := r.Descriptor().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		fd := fields.Get(i)
+		if fd.IsList() || fd.IsMap() {
+			continue
+		}
+		switch fd.Kind() {
+		case protoreflect.StringKind:
+			r.Set(fd, protoreflect.ValueOfString(strings.Repeat("x", f.oversize)))
+		case protoreflect.BytesKind:
+			r.Set(fd, protoreflect.ValueOfBytes(make([]byte, f.oversize)))
+		default:
+			continue
+		}
+		countFault(method, faultTypeOversize)
+		return
+	}
+}
+
+func unaryFaultInterceptor(conns *GrpcFaultConns) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if skipFault(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		fault, err := parseGrpcFault(ctx, conns)
+		if err != nil {
+			return nil, err
+		}
+		if fault == nil {
+			return handler(ctx, req)
+		}
+		if err := fault.before(ctx, info.FullMethod); err != nil {
+			return nil, err
+		}
+		if fault.abortAfter >= 0 {
+			// A unary call has no messages to abort after.
+			return nil, fault.abort(ctx, info.FullMethod)
+		}
+		resp, err := handler(ctx, req)
+		if err == nil {
+			fault.pad(resp, info.FullMethod)
+		}
+		return resp, err
+	}
+}
+
+func streamFaultInterceptor(conns *GrpcFaultConns) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if skipFault(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		fault, err := parseGrpcFault(ss.Context(), conns)
+		if err != nil {
+			return err
+		}
+		if fault == nil {
+			return handler(srv, ss)
+		}
+		if err := fault.before(ss.Context(), info.FullMethod); err != nil {
+			return err
+		}
+		stream := &faultServerStream{
+			ServerStream: ss,
+			fault:        fault,
+			method:       info.FullMethod,
+			countRecv:    info.IsClientStream && !info.IsServerStream,
+		}
+		if err := stream.check(); err != nil {
+			return err
+		}
+		err = handler(srv, stream)
+		if stream.aborted != nil {
+			// The handler may not return the error of Send or Recv.
+			return stream.aborted
+		}
+		return err
+	}
+}
+
+// faultServerStream pads sent messages and aborts after abortAfter messages, counting
+// received messages of client streams and sent messages otherwise.
+type faultServerStream struct {
+	grpc.ServerStream
+	fault     *grpcFault
+	method    string
+	countRecv bool
+	messages  int
+	aborted   error
+}
+
+func (s *faultServerStream) SendMsg(m interface{}) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	s.fault.pad(m, s.method)
+	err := s.ServerStream.SendMsg(m)
+	if err == nil && !s.countRecv {
+		s.messages++
+	}
+	return err
+}
+
+func (s *faultServerStream) RecvMsg(m interface{}) error {
+	if err := s.check(); err != nil {
+		return err
+	}
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil && s.countRecv {
+		s.messages++
+	}
+	return err
+}
+
+func (s *faultServerStream) check() error {
+	if s.aborted == nil && s.fault.abortAfter >= 0 && s.messages >= s.fault.abortAfter {
+		s.aborted = s.fault.abort(s.Context(), s.method)
+	}
+	return s.aborted
+}
+
+func countFault(method string, fault string) {
+	if counter, ok := grpcFaultMetric.MetricCollector.(*prometheus.CounterVec); ok {
+		counter.WithLabelValues(method, fault).Inc()
+	}
+}
+
+// GrpcFaultConns tracks the connections accepted by the listeners of a grpc server, so
+// FaultResetConnection can reset the connection of a call, with every stream of the
+// connection. The connections are keyed by their remote address.
+type GrpcFaultConns struct {
+	conns sync.Map
+}
+
+func NewGrpcFaultConns() *GrpcFaultConns {
+	return &GrpcFaultConns{}
+}
+
+// Listener tracks the connections accepted by lis.
+func (f *GrpcFaultConns) Listener(lis net.Listener) net.Listener {
+	return &faultListener{Listener: lis, conns: f}
+}
+
+// reset closes the connection from address with a TCP RST. Calls not served by a tracked
+// listener, like the REST/JSON transcoding, have no connection to reset.
+func (f *GrpcFaultConns) reset(address string) {
+	if f == nil {
+		return
+	}
+	value, ok := f.conns.Load(address)
+	if !ok {
+		return
+	}
+	c := value.(*faultConn)
+	if tcpConn, ok := c.Conn.(*net.TCPConn); ok {
+		_ = tcpConn.SetLinger(0)
+	}
+	_ = c.Close()
+}
+
+type faultListener struct {
+	net.Listener
+	conns *GrpcFaultConns
+}
+
+func (l *faultListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	c := &faultConn{Conn: conn, conns: l.conns}
+	l.conns.conns.Store(conn.RemoteAddr().String(), c)
+	return c, nil
+}
+
+type faultConn struct {
+	net.Conn
+	conns *GrpcFaultConns
+	once  sync.Once
+}
+
+func (c *faultConn) Close() error {
+	c.once.Do(func() {
+		c.conns.conns.Delete(c.RemoteAddr().String())
+	})
+	return c.Conn.Close()
+}
@@ -65,6 +65,55 @@ var standardMetrics = []*Metric{
 	reqSizeMetric,
 }
 
+var grpcReqCountMetric = &Metric{
+	ID:          "grpcReqCount",
+	Name:        "grpc_requests_total",
+	Description: "How many gRPC requests processed, partitioned by status code, method and type.",
+	Type:        "counter_vec",
+	Args:        []string{"code", "method", "type"},
+}
+
+var grpcReqDurationMetric = &Metric{
+	ID:          "grpcReqDuration",
+	Name:        "grpc_request_duration_seconds",
+	Description: "The gRPC request latencies in seconds.",
+	Type:        "histogram_vec",
+	Args:        []string{"code", "method", "type"},
+}
+
+var grpcMsgReceivedMetric = &Metric{
+	ID:          "grpcMsgReceived",
+	Name:        "grpc_msg_received_total",
+	Description: "How many gRPC stream messages received, partitioned by method.",
+	Type:        "counter_vec",
+	Args:        []string{"method"},
+}
+
+var grpcMsgSentMetric = &Metric{
+	ID:          "grpcMsgSent",
+	Name:        "grpc_msg_sent_total",
+	Description: "How many gRPC stream messages sent, partitioned by method.",
+	Type:        "counter_vec",
+	Args:        []string{"method"},
+}
+
+var grpcFaultMetric = &Metric{
+	ID:          "grpcFault",
+	Name:        "grpc_faults_total",
+	Description: "How many gRPC faults injected, partitioned by method and fault.",
+	Type:        "counter_vec",
+	Args:        []string{"method", "fault"},
+}
+
+// grpcMetrics are observed by the grpc interceptors through their MetricCollector.
+var grpcMetrics = []*Metric{
+	grpcReqCountMetric,
+	grpcReqDurationMetric,
+	grpcMsgReceivedMetric,
+	grpcMsgSentMetric,
+	grpcFaultMetric,
+}
+
 type RequestCounterURLLabelMappingFn func(c *gin.Context) string
 
 type metricMiddleWareBuilder struct {
@@ -227,7 +276,7 @@ func StartMetric(g *gin.Engine, option *options.Option) {
 		ReqCntURLLabelMappingFn: func(c *gin.Context) string {
 			return c.Request.URL.Path // i.e. by default do nothing, i.e. return URL as is
 		},
-		MetricsList: standardMetrics,
+		MetricsList: append(standardMetrics, grpcMetrics...),
 	}
 	builder.registerMetrics()
 	g.Use(builder.middleware())
@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type Response struct {
 	Args     map[string]string `json:"args"`
 	Form     map[string]string `json:"form"`
@@ -9,7 +11,9 @@ type Response struct {
 	Url      string            `json:"url"`
 	Envs     map[string]string `json:"envs"`
 	HostName string            `json:"host_name"`
+	Meta     map[string]string `json:"meta"`
 	Body     string            `json:"body"`
+	Sampling *Sampling         `json:"sampling,omitempty"`
 }
 
 type ResponseAny struct {
@@ -36,3 +40,78 @@ type ConditionRouteDto struct {
 	Runtime       bool   `json:"runtime" yaml:"runtime"`
 	ConfigVersion string `json:"configVersion" yaml:"configVersion" binding:"required"`
 }
+
+// CallGraph is a hop of the /service call graph, calling its children in parallel.
+type CallGraph struct {
+	Service  string       `json:"service"`
+	Children []*CallGraph `json:"children,omitempty"`
+}
+
+// CallResult is the call tree returned by /service?graph=, one node per hop.
+type CallResult struct {
+	// Hop as named in the graph, empty for the service receiving the request.
+	Hop      string `json:"hop,omitempty"`
+	Service  string `json:"service,omitempty"`
+	HostName string `json:"host_name,omitempty"`
+	// HTTP status of the hop, grpc hops are mapped like the REST/JSON transcoding.
+	Status   int    `json:"status"`
+	GrpcCode string `json:"grpc_code,omitempty"`
+	// TLS of https hops.
+	TLS *HopTLS `json:"tls,omitempty"`
+	// Attempts of the hop, set when it was retried.
+	Attempts int           `json:"attempts,omitempty"`
+	Latency  string        `json:"latency"`
+	Error    string        `json:"error,omitempty"`
+	Children []*CallResult `json:"children,omitempty"`
+}
+
+// ServiceError is the response of /service naming the hop of the chain that failed and why.
+type ServiceError struct {
+	// Hop as named in the services, e.g. grpc://orders:9091;timeout=1s.
+	Hop string `json:"hop"`
+	// Service and host calling the hop.
+	Service  string `json:"service"`
+	HostName string `json:"host_name"`
+	Status   int    `json:"status"`
+	GrpcCode string `json:"grpc_code,omitempty"`
+	Attempts int    `json:"attempts"`
+	Error    string `json:"error"`
+	// Response body of the hop.
+	Body string `json:"body,omitempty"`
+}
+
+// HopTLS is the tls connection and server certificate of a https hop.
+type HopTLS struct {
+	Version     string   `json:"version"`
+	CipherSuite string   `json:"cipher_suite"`
+	ServerName  string   `json:"server_name,omitempty"`
+	Subject     string   `json:"subject"`
+	Issuer      string   `json:"issuer"`
+	DNSNames    []string `json:"dns_names,omitempty"`
+	URIs        []string `json:"uris,omitempty"`
+	IPAddresses []string `json:"ip_addresses,omitempty"`
+	NotAfter    string   `json:"not_after"`
+}
+
+// Span is a finished span kept by the trace recorder and served by /debug/traces.
+type Span struct {
+	TraceID  string            `json:"trace_id"`
+	SpanID   string            `json:"span_id"`
+	ParentID string            `json:"parent_id,omitempty"`
+	Service  string            `json:"service"`
+	Name     string            `json:"name"`
+	Kind     string            `json:"kind,omitempty"`
+	Start    time.Time         `json:"start"`
+	Duration string            `json:"duration"`
+	Error    bool              `json:"error,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+}
+
+// Sampling is the trace sampling decision of a request, reported in the / response.
+type Sampling struct {
+	Sampled bool `json:"sampled"`
+	// Reason is force, debug, parent, route or rate.
+	Reason string `json:"reason"`
+	// Rate of route and rate decisions.
+	Rate *float64 `json:"rate,omitempty"`
+}
@@ -2,7 +2,11 @@ package options
 
 import (
 	"fmt"
+	"strings"
+	"time"
+
 	"github.com/spf13/pflag"
+	"httpbin/pkg/logs"
 	"httpbin/pkg/utils"
 )
 
@@ -12,25 +16,89 @@ const (
 	DefaultVersion   = "v1"
 )
 
+const (
+	DefaultPodInfoLabelsFile      = "/etc/podinfo/labels"
+	DefaultPodInfoAnnotationsFile = "/etc/podinfo/annotations"
+	// Only annotations with this prefix become service metadata, with the prefix removed.
+	PodInfoMetaAnnotationPrefix = "meta.httpbin.io/"
+)
+
+const (
+	MetaVersion   = "version"
+	MetaSubSystem = "subsystem"
+	MetaNameSpace = "namespace"
+	MetaNode      = "node"
+	MetaZone      = "zone"
+)
+
 const (
 	ServiceRegistryTypeConsul = "consul"
 	ServiceRegistryTypeNacos  = "nacos"
+	ServiceRegistryTypeEtcd   = "etcd"
 	ServiceRegistryTypeNone   = "none"
 )
 
+const (
+	ConsulCheckTypeHttp = "http"
+	ConsulCheckTypeTcp  = "tcp"
+	ConsulCheckTypeGrpc = "grpc"
+	ConsulCheckTypeTtl  = "ttl"
+)
+
+const (
+	DiscoveryTypeNone     = "none"
+	DiscoveryTypeStatic   = "static"
+	DiscoveryTypeDns      = "dns"
+	DiscoveryTypeRegistry = "registry"
+)
+
+const (
+	LoadBalancerRoundRobin   = "round-robin"
+	LoadBalancerRandom       = "random"
+	LoadBalancerLeastRequest = "least-request"
+)
+
+const (
+	OrderStoreMemory = "memory"
+	OrderStoreFile   = "file"
+)
+
 const (
 	Skywalking = "skywalking"
 	Zipkin     = "zipkin"
+	Otel       = "otel"
+)
+
+const (
+	OtelProtocolGrpc = "grpc"
+	OtelProtocolHttp = "http"
+)
+
+// Trace propagation formats of --trace-extract and --trace-inject.
+const (
+	PropagationW3C     = "w3c"
+	PropagationB3      = "b3"
+	PropagationB3Multi = "b3multi"
+	PropagationJaeger  = "jaeger"
+	PropagationDatadog = "datadog"
 )
 
 type Option struct {
 	TraceProvider         string
 	SkywalkingGrpcAddress string
 	ZipkinEndpointURL     string
+	OtelEndpoint          string
+	OtelProtocol          string
+	OtelInsecure          bool
+	TraceExtract          []string
+	TraceInject           []string
+	TraceBufferSize       int
+	TraceExport           string
 	ServerPort            uint32
 	ServerAddress         string
 	ServerIp              string
 	SamplingRate          float64
+	RouteSamplingRates    map[string]string
 	ServiceTags           string
 	ServiceMeta           map[string]string
 	ServiceCheckPath      string
@@ -41,21 +109,60 @@ type Option struct {
 	NameSpace    string
 	Version      string
 	NodeName     string
+	Zone         string
+
+	PodInfoLabelsFile      string
+	PodInfoAnnotationsFile string
 
 	RegistryType string
 
 	ConsulServerAddress string
 	ConsulDatacenter    string
 	ConsulAuthToken     string
+	ConsulCheckType     string
+	ConsulCheckInterval time.Duration
+	ConsulCheckTimeout  time.Duration
+	ConsulDeregister    time.Duration
 
 	NacosServerAddress string
 	NacosNamespaceId   string
 	NacosGroupName     string
 	NacosUsername      string
 	NacosPassword      string
+	NacosWeight        float64
+	NacosClusterName   string
+	NacosEphemeral     bool
+	NacosMetadata      map[string]string
+
+	EtcdEndpoints  string
+	EtcdPrefix     string
+	EtcdTTL        int64
+	EtcdUsername   string
+	EtcdPassword   string
+	EtcdCACertFile string
+	EtcdCertFile   string
+	EtcdKeyFile    string
+
+	DiscoveryType       string
+	DiscoveryFile       string
+	DiscoveryDnsService string
+	DiscoveryDnsDomain  string
+	LoadBalancer        string
 
-	GrpcEnable bool
-	GrpcPort   uint32
+	HopTimeout      time.Duration
+	HopRetries      int
+	HopRetryBackoff time.Duration
+	HopMaxRetries   int
+	HopMaxBackoff   time.Duration
+
+	GrpcEnable  bool
+	GrpcPort    uint32
+	GrpcTls     bool
+	GrpcTlsPort uint32
+	// Serve grpc with the Go http2 server, which can reset a single stream.
+	GrpcStreamReset bool
+	OrderStore      string
+	OrderStoreFile  string
 
 	HttpsEnable   bool
 	HttpsPort     uint32
@@ -64,29 +171,84 @@ type Option struct {
 	TlsKeyFile    string
 	TlsServerName string
 	MTLS          bool
+
+	ShutdownDelay       time.Duration
+	ShutdownGracePeriod time.Duration
+}
+
+// GrpcPlaintext reports whether grpc is served without tls on GrpcPort.
+func (o *Option) GrpcPlaintext() bool {
+	return !o.GrpcTls || o.GrpcTlsPort != o.GrpcPort
 }
 
 func (o *Option) AddFlags(flags *pflag.FlagSet) {
-	flags.StringVar(&o.TraceProvider, "trace-provider", "", "Trace provider type")
+	flags.StringVar(&o.TraceProvider, "trace-provider", "", "Trace provider type: skywalking, zipkin or otel")
 	flags.StringVar(&o.SkywalkingGrpcAddress, "skywalking-grpc-address", "", "Skywalking grpc address.")
 	flags.StringVar(&o.ZipkinEndpointURL, "zipkin-endpoint-url", "", "Zipkin http endpoint url.")
+	flags.StringVar(&o.OtelEndpoint, "otel-endpoint", "", "OpenTelemetry OTLP endpoint host:port, default OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4317 for grpc and localhost:4318 for http.")
+	flags.StringVar(&o.OtelProtocol, "otel-protocol", OtelProtocolGrpc, "OpenTelemetry OTLP protocol: grpc or http.")
+	flags.BoolVar(&o.OtelInsecure, "otel-insecure", true, "Export OTLP without tls.")
+	flags.StringSliceVar(&o.TraceExtract, "trace-extract", []string{PropagationW3C, PropagationB3, PropagationB3Multi, PropagationJaeger, PropagationDatadog},
+		"Trace propagation formats extracted from requests in order of precedence: w3c, b3, b3multi, jaeger or datadog.")
+	flags.StringSliceVar(&o.TraceInject, "trace-inject", nil,
+		"Trace propagation formats injected into the next hops, default b3multi for zipkin, w3c for otel and none without --trace-provider.")
+	flags.IntVar(&o.TraceBufferSize, "trace-buffer-size", 0, "Keep the last N finished spans, served at /debug/traces. Requires --trace-provider.")
+	flags.StringVar(&o.TraceExport, "trace-export", "", "Write finished spans as JSON lines to stdout or a file. Requires --trace-provider.")
 	flags.Uint32Var(&o.ServerPort, "server-port", 80, "The server port binds to.")
 	flags.Float64Var(&o.SamplingRate, "sample-rate", 1.0, "Trace sample rate")
+	flags.StringToStringVar(&o.RouteSamplingRates, "route-sample-rate", nil,
+		"Trace sample rate of a route, route=rate, repeatable. Routes are gin paths such as /service or grpc methods such as /httpbin.Echo/service, a trailing * matches a prefix. Overrides --sample-rate.")
 	flags.StringVar(&o.ServiceCheckPath, "service-check-path", "/ping", "service check path.")
 	flags.StringVar(&o.RegistryType, "registry-type", "none", "Registry type")
 	flags.StringVar(&o.ServiceTags, "service-tags", "", "service tags.")
+	flags.StringToStringVar(&o.ServiceMeta, "service-meta", nil, "service metadata key=value, repeatable. Overrides SERVICE_META_* envs and pod labels.")
+	flags.StringVar(&o.PodInfoLabelsFile, "podinfo-labels-file", DefaultPodInfoLabelsFile, "Downward API pod labels file, labels become service metadata.")
+	flags.StringVar(&o.PodInfoAnnotationsFile, "podinfo-annotations-file", DefaultPodInfoAnnotationsFile, "Downward API pod annotations file, annotations prefixed "+PodInfoMetaAnnotationPrefix+" become service metadata.")
 	flags.StringVar(&o.ConsulServerAddress, "consul-server-address", "", "Consul server address.")
 	flags.StringVar(&o.ConsulDatacenter, "consul-data-center", "dc1", "Consul data center.")
 	flags.StringVar(&o.ConsulAuthToken, "consul-auth-token", "", "Consul server auth token")
+	flags.StringVar(&o.ConsulCheckType, "consul-check-type", "", "Consul check type: http, tcp, grpc or ttl. Empty checks each instance by its protocol, http and grpc only apply to instances of that protocol.")
+	flags.DurationVar(&o.ConsulCheckInterval, "consul-check-interval", 10*time.Second, "Consul check interval, also the heartbeat interval of ttl check.")
+	flags.DurationVar(&o.ConsulCheckTimeout, "consul-check-timeout", 5*time.Second, "Consul check timeout, ttl check expires after interval plus timeout.")
+	flags.DurationVar(&o.ConsulDeregister, "consul-deregister-after", 60*time.Second, "Consul deregister critical service after.")
 
 	flags.StringVar(&o.NacosServerAddress, "nacos-server-address", "", "nacos server address.")
 	flags.StringVar(&o.NacosNamespaceId, "nacos-namespace-id", "", "nacos namespace id")
 	flags.StringVar(&o.NacosGroupName, "nacos-group-name", "", "nacos group name")
 	flags.StringVar(&o.NacosUsername, "nacos-username", "", "nacos username")
 	flags.StringVar(&o.NacosPassword, "nacos-password", "", "nacos password")
+	flags.Float64Var(&o.NacosWeight, "nacos-weight", 10, "nacos instance weight")
+	flags.StringVar(&o.NacosClusterName, "nacos-cluster-name", "", "nacos instance cluster name")
+	flags.BoolVar(&o.NacosEphemeral, "nacos-ephemeral", true, "nacos ephemeral instance, false registers a persistent instance")
+	flags.StringToStringVar(&o.NacosMetadata, "nacos-metadata", nil, "nacos instance metadata, key=value pairs separated by comma")
+
+	flags.StringVar(&o.EtcdEndpoints, "etcd-endpoints", "", "etcd endpoints, separated by comma.")
+	flags.StringVar(&o.EtcdPrefix, "etcd-prefix", "/httpbin/services", "etcd key prefix of registered services.")
+	flags.Int64Var(&o.EtcdTTL, "etcd-ttl", 10, "etcd lease ttl in seconds.")
+	flags.StringVar(&o.EtcdUsername, "etcd-username", "", "etcd username")
+	flags.StringVar(&o.EtcdPassword, "etcd-password", "", "etcd password")
+	flags.StringVar(&o.EtcdCACertFile, "etcd-cacert", "", "etcd CA cert file")
+	flags.StringVar(&o.EtcdCertFile, "etcd-cert", "", "etcd client cert file")
+	flags.StringVar(&o.EtcdKeyFile, "etcd-key", "", "etcd client key file")
+
+	flags.StringVar(&o.DiscoveryType, "discovery-type", "none", "Discovery type of next hops in /service: none, static, dns or registry")
+	flags.StringVar(&o.DiscoveryFile, "discovery-file", "", "Static discovery yaml file mapping service name to endpoints")
+	flags.StringVar(&o.DiscoveryDnsService, "discovery-dns-service", "http", "DNS SRV service name of http hops, lookup _service._tcp.name, https, grpc and grpcs hops lookup _https._tcp.name, _grpc._tcp.name and _grpcs._tcp.name")
+	flags.StringVar(&o.DiscoveryDnsDomain, "discovery-dns-domain", "", "DNS SRV domain appended to service name")
+	flags.StringVar(&o.LoadBalancer, "load-balancer", "round-robin", "Load balancer of next hops: round-robin, random or least-request")
+	flags.DurationVar(&o.HopTimeout, "hop-timeout", 30*time.Second, "Timeout of each attempt to call a next hop in /service, 0 means no timeout. Overridden by hop;timeout=")
+	flags.IntVar(&o.HopRetries, "hop-retries", 0, "Retries of a next hop in /service on network errors and 5xx, for idempotent methods only. Overridden by hop;retries= for any method")
+	flags.DurationVar(&o.HopRetryBackoff, "hop-retry-backoff", 100*time.Millisecond, "Backoff before the first retry of a next hop, doubled for every retry. Overridden by hop;backoff=")
+	flags.IntVar(&o.HopMaxRetries, "hop-max-retries", 5, "Max retries of a next hop, larger --hop-retries and hop;retries= are rejected")
+	flags.DurationVar(&o.HopMaxBackoff, "hop-max-retry-backoff", 10*time.Second, "Max backoff before a retry of a next hop")
 
 	flags.BoolVar(&o.GrpcEnable, "grpc-enable", true, "grpc enable")
 	flags.Uint32Var(&o.GrpcPort, "grpc-port", 9091, "grpc demo order port")
+	flags.BoolVar(&o.GrpcTls, "grpc-tls", false, "Serve grpc with tls using --cacert, --cert, --key and --mtls")
+	flags.Uint32Var(&o.GrpcTlsPort, "grpc-tls-port", 0, "grpc tls port, serving plaintext grpc on --grpc-port as well. Default serves only tls on --grpc-port")
+	flags.BoolVar(&o.GrpcStreamReset, "grpc-stream-reset", false, "Serve grpc with the Go http2 server instead of the grpc transport, so x-httpbin-fault-reset-stream can reset a single HTTP/2 stream")
+	flags.StringVar(&o.OrderStore, "order-store", "memory", "grpc demo order store: memory or file")
+	flags.StringVar(&o.OrderStoreFile, "order-store-file", "./orders.json", "grpc demo order snapshot file of file order store")
 
 	flags.BoolVar(&o.HttpsEnable, "https-enable", false, "https enable")
 	flags.Uint32Var(&o.HttpsPort, "https-port", 443, "https port")
@@ -96,10 +258,89 @@ func (o *Option) AddFlags(flags *pflag.FlagSet) {
 	flags.StringVar(&o.TlsServerName, "server-name", "", "tls server name")
 	flags.BoolVar(&o.MTLS, "mtls", false, "mtls enable")
 
+	flags.DurationVar(&o.ShutdownDelay, "shutdown-delay", 5*time.Second, "Time to wait after readiness fails before draining listeners")
+	flags.DurationVar(&o.ShutdownGracePeriod, "shutdown-grace-period", 30*time.Second, "Max time of the whole shutdown, including --shutdown-delay and draining in-flight requests and streams")
+
+}
+
+// Validate rejects the unknown values of the enum flags and out of range values.
+func (o *Option) Validate() error {
+	switch o.ConsulCheckType {
+	case "", ConsulCheckTypeHttp, ConsulCheckTypeTcp, ConsulCheckTypeGrpc, ConsulCheckTypeTtl:
+	default:
+		return fmt.Errorf("not support consul check type %s, must be http, tcp, grpc or ttl", o.ConsulCheckType)
+	}
+	if o.ShutdownDelay >= o.ShutdownGracePeriod {
+		return fmt.Errorf("shutdown delay %s must be less than the shutdown grace period %s", o.ShutdownDelay, o.ShutdownGracePeriod)
+	}
+	if o.HopMaxBackoff <= 0 {
+		return fmt.Errorf("hop max retry backoff %s must be positive", o.HopMaxBackoff)
+	}
+	if o.HopRetries < 0 || o.HopRetries > o.HopMaxRetries {
+		return fmt.Errorf("hop retries %d must be between 0 and --hop-max-retries %d", o.HopRetries, o.HopMaxRetries)
+	}
+	return nil
 }
 
 func (o *Option) Complete() {
 	o.ServerAddress = fmt.Sprintf(":%d", o.ServerPort)
+	if o.GrpcTls && o.GrpcTlsPort == 0 {
+		o.GrpcTlsPort = o.GrpcPort
+	}
+	o.completeServiceMeta()
+}
+
+// completeServiceMeta merges service metadata from, in increasing precedence, the
+// instance identity, pod labels and annotations, SERVICE_META_* envs and --service-meta flags.
+func (o *Option) completeServiceMeta() {
+	meta := map[string]string{
+		MetaVersion:   o.Version,
+		MetaSubSystem: o.SubSystem,
+		MetaNameSpace: o.NameSpace,
+		MetaNode:      o.NodeName,
+		MetaZone:      o.Zone,
+	}
+	labels, err := utils.ReadPodInfoFile(o.PodInfoLabelsFile)
+	if err != nil {
+		logs.Warnf("read pod labels %s error:%v", o.PodInfoLabelsFile, err)
+	}
+	for k, v := range labels {
+		meta[k] = v
+	}
+	annotations, err := utils.ReadPodInfoFile(o.PodInfoAnnotationsFile)
+	if err != nil {
+		logs.Warnf("read pod annotations %s error:%v", o.PodInfoAnnotationsFile, err)
+	}
+	for k, v := range annotations {
+		if strings.HasPrefix(k, PodInfoMetaAnnotationPrefix) {
+			meta[strings.TrimPrefix(k, PodInfoMetaAnnotationPrefix)] = v
+		}
+	}
+	for k, v := range utils.GetServiceMetaEnvs() {
+		meta[k] = v
+	}
+	for k, v := range o.ServiceMeta {
+		meta[k] = v
+	}
+
+	o.ServiceMeta = make(map[string]string, len(meta))
+	for k, v := range meta {
+		if len(v) == 0 {
+			continue
+		}
+		o.ServiceMeta[sanitizeMetaKey(k)] = v
+	}
+}
+
+// sanitizeMetaKey replaces characters Consul rejects in metadata keys, e.g. label
+// app.kubernetes.io/name becomes app_kubernetes_io_name, so all registries agree.
+func sanitizeMetaKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
 }
 
 func (o *Option) FillEnvs() {
@@ -119,6 +360,7 @@ func (o *Option) FillEnvs() {
 	}
 	o.ServerIp = utils.GetIP()
 	o.NodeName = utils.GetNodeName()
+	o.Zone = utils.GetZone()
 }
 
 func NewOption() *Option {
@@ -0,0 +1,119 @@
+package probe
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+)
+
+// Service names of the grpc health checking service, matching the http probes.
+// The empty service, used by Kubernetes, Consul and Envoy when no service is set, reports
+// liveness only, so a grpc liveness probe without service does not restart a pod that is
+// just not ready. Readiness checks set service readiness.
+const (
+	HealthServiceLiveness      = "liveness"
+	HealthServiceLivenessFile  = "livenessfile"
+	HealthServiceReadiness     = "readiness"
+	HealthServiceReadinessFile = "readinessfile"
+	HealthServiceStartup       = "startup"
+	HealthServiceStartupFile   = "startupfile"
+)
+
+// File probes are polled by Watch as files have no change notification.
+const healthWatchInterval = time.Second
+
+// HealthServer implements grpc.health.v1.Health from the same probe state as the http
+// probe handlers, so PUT /prob/readiness?status=false also fails grpc health checks.
+type HealthServer struct {
+	healthpb.UnimplementedHealthServer
+	mutex   sync.Mutex
+	checks  map[string]func() bool
+	changed chan struct{}
+}
+
+// NewHealthServer returns a HealthServer for the probe services and services, which
+// are serving while the server is live and ready, e.g. order.OrderManagement.
+func NewHealthServer(services ...string) *HealthServer {
+	ready := func() bool {
+		return IsLive() && IsReady()
+	}
+	s := &HealthServer{
+		checks: map[string]func() bool{
+			"":                         IsLive,
+			HealthServiceLiveness:      IsLive,
+			HealthServiceLivenessFile:  IsLiveFile,
+			HealthServiceReadiness:     IsReady,
+			HealthServiceReadinessFile: IsReadyFile,
+			HealthServiceStartup:       IsStarted,
+			HealthServiceStartupFile:   IsStartedFile,
+		},
+		changed: make(chan struct{}),
+	}
+	for _, service := range services {
+		s.checks[service] = ready
+	}
+	Subscribe(func(State) {
+		s.notify()
+	})
+	return s
+}
+
+func (s *HealthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	serving, ok := s.status(req.Service)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "unknown service %s", req.Service)
+	}
+	return &healthpb.HealthCheckResponse{Status: serving}, nil
+}
+
+// Watch sends the status of the service on start and on every change, unknown services
+// are reported as SERVICE_UNKNOWN as required by the health checking protocol.
+func (s *HealthServer) Watch(req *healthpb.HealthCheckRequest, stream healthpb.Health_WatchServer) error {
+	ticker := time.NewTicker(healthWatchInterval)
+	defer ticker.Stop()
+	last := healthpb.HealthCheckResponse_ServingStatus(-1)
+	for {
+		s.mutex.Lock()
+		changed := s.changed
+		s.mutex.Unlock()
+		serving, ok := s.status(req.Service)
+		if !ok {
+			serving = healthpb.HealthCheckResponse_SERVICE_UNKNOWN
+		}
+		if serving != last {
+			if err := stream.Send(&healthpb.HealthCheckResponse{Status: serving}); err != nil {
+				return status.Error(codes.Canceled, "stream has ended")
+			}
+			last = serving
+		}
+		select {
+		case <-changed:
+		case <-ticker.C:
+		case <-stream.Context().Done():
+			return status.Error(codes.Canceled, "stream has ended")
+		}
+	}
+}
+
+func (s *HealthServer) status(service string) (healthpb.HealthCheckResponse_ServingStatus, bool) {
+	check, ok := s.checks[service]
+	if !ok {
+		return healthpb.HealthCheckResponse_SERVICE_UNKNOWN, false
+	}
+	if !check() {
+		return healthpb.HealthCheckResponse_NOT_SERVING, true
+	}
+	return healthpb.HealthCheckResponse_SERVING, true
+}
+
+// notify wakes up all watchers.
+func (s *HealthServer) notify() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
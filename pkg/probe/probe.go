@@ -0,0 +1,115 @@
+package probe
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"httpbin/pkg/utils"
+)
+
+// Files checked by the *File probes, e.g. touch ./readiness.txt
+const (
+	LiveFile    = "./healthz.txt"
+	ReadyFile   = "./readiness.txt"
+	StartedFile = "./startup.txt"
+)
+
+// State is a snapshot of the liveness, readiness and startup probe state.
+type State struct {
+	Live    bool `json:"live"`
+	Ready   bool `json:"ready"`
+	Started bool `json:"started"`
+}
+
+var (
+	live    atomic.Bool
+	ready   atomic.Bool
+	started atomic.Bool
+
+	mutex       sync.Mutex
+	subscribers []func(State)
+	// changed wakes the notify goroutine, holding at most one pending change.
+	changed    = make(chan struct{}, 1)
+	notifyOnce sync.Once
+)
+
+func init() {
+	live.Store(true)
+	ready.Store(true)
+	started.Store(true)
+}
+
+func IsLive() bool {
+	return live.Load()
+}
+
+func IsReady() bool {
+	return ready.Load()
+}
+
+func IsStarted() bool {
+	return started.Load()
+}
+
+func IsLiveFile() bool {
+	return utils.FileExisted(LiveFile)
+}
+
+func IsReadyFile() bool {
+	return IsReady() && utils.FileExisted(ReadyFile)
+}
+
+func IsStartedFile() bool {
+	return utils.FileExisted(StartedFile)
+}
+
+func SetLive(value bool) {
+	set(&live, value)
+}
+
+func SetReady(value bool) {
+	set(&ready, value)
+}
+
+func SetStarted(value bool) {
+	set(&started, value)
+}
+
+func Current() State {
+	return State{Live: IsLive(), Ready: IsReady(), Started: IsStarted()}
+}
+
+// Subscribe registers fn to be called with the state whenever a probe changes. Subscribers
+// are called in order by a single goroutine, so a slow subscriber never blocks the probe
+// change. Changes made while they run are coalesced into one call with the latest state.
+func Subscribe(fn func(State)) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	subscribers = append(subscribers, fn)
+	notifyOnce.Do(func() {
+		go notify()
+	})
+}
+
+func set(probe *atomic.Bool, value bool) {
+	if probe.Swap(value) == value {
+		return
+	}
+	select {
+	case changed <- struct{}{}:
+	default:
+		// A change is already pending, its call reads the latest state.
+	}
+}
+
+func notify() {
+	for range changed {
+		mutex.Lock()
+		fns := append([]func(State){}, subscribers...)
+		mutex.Unlock()
+		state := Current()
+		for _, fn := range fns {
+			fn(state)
+		}
+	}
+}
@@ -4,11 +4,13 @@ import (
 	"context"
 	"fmt"
 	"strconv"
+	"sync"
 	"time"
 
 	consulapi "github.com/hashicorp/consul/api"
 	"httpbin/pkg/logs"
 	"httpbin/pkg/options"
+	"httpbin/pkg/probe"
 )
 
 const (
@@ -16,12 +18,19 @@ const (
 )
 
 type ConsulServiceRegistry struct {
-	client *consulapi.Client
+	client          *consulapi.Client
+	checkType       string
+	checkInterval   time.Duration
+	checkTimeout    time.Duration
+	deregisterAfter time.Duration
+	mutex           sync.Mutex
+	heartbeats      map[string]context.CancelFunc
 }
 
 func (c *ConsulServiceRegistry) RegisterService(ctx context.Context, service *Service) error {
 	err := c.doRegistry(service)
 	if err == nil {
+		c.startHeartbeat(service)
 		return nil
 	}
 	logs.Errorf("registry service %+v, error:%v", service, err)
@@ -34,6 +43,7 @@ func (c *ConsulServiceRegistry) RegisterService(ctx context.Context, service *Se
 			case <-ticker.C:
 				err = c.doRegistry(service)
 				if err == nil {
+					c.startHeartbeat(service)
 					close(stop)
 					return
 				} else {
@@ -55,25 +65,158 @@ func (c *ConsulServiceRegistry) doRegistry(service *Service) error {
 	logs.Infof("start registry service:%+v", service)
 	port, _ := strconv.Atoi(service.Port)
 	registration := &consulapi.AgentServiceRegistration{
-		ID:      service.InstanceName,
+		ID:      service.InstanceID(),
 		Name:    service.ServiceName,
 		Address: service.Ip,
 		Port:    port,
 		Tags:    service.ServiceTags,
 		Meta:    service.ServiceMeta,
 	}
-	check := &consulapi.AgentServiceCheck{
-		HTTP:                           fmt.Sprintf("http://%s:%s%s", service.Ip, service.Port, service.CheckPath),
-		Timeout:                        "5s",
-		Interval:                       "600s",
-		DeregisterCriticalServiceAfter: "60s",
-		//GRPC:                           fmt.Sprintf("%s:%s%s", service.Ip, service.Port, service.CheckPath),
-	}
-	registration.Check = check
+	registration.Check = c.newCheck(service)
 	err := c.client.Agent().ServiceRegister(registration)
 	return err
 }
 
+func (c *ConsulServiceRegistry) newCheck(service *Service) *consulapi.AgentServiceCheck {
+	check := &consulapi.AgentServiceCheck{
+		CheckID:                        checkID(service),
+		DeregisterCriticalServiceAfter: c.deregisterAfter.String(),
+	}
+	address := fmt.Sprintf("%s:%s", service.Ip, service.Port)
+	// The empty grpc health service reports liveness only.
+	grpcCheck := fmt.Sprintf("%s/%s", address, probe.HealthServiceReadiness)
+	switch {
+	case c.checkType == options.ConsulCheckTypeTtl:
+		// httpbin heartbeats the check itself, see heartbeat.
+		check.TTL = (c.checkInterval + c.checkTimeout).String()
+		check.Status = consulapi.HealthPassing
+		return check
+	case c.checkType == options.ConsulCheckTypeTcp:
+		check.TCP = address
+	case service.Protocol == Grpc:
+		check.GRPC = grpcCheck
+	case (service.Protocol == Https || service.Protocol == Grpcs) && service.MTLS:
+		// Consul can not present a client certificate per check, so only probe the port.
+		check.TCP = address
+	case service.Protocol == Grpcs:
+		check.GRPC = grpcCheck
+		check.GRPCUseTLS = true
+		check.TLSSkipVerify = true
+	case service.Protocol == Https:
+		check.HTTP = fmt.Sprintf("https://%s%s", address, service.CheckPath)
+		check.TLSSkipVerify = true
+	default:
+		check.HTTP = fmt.Sprintf("http://%s%s", address, service.CheckPath)
+	}
+	check.Interval = c.checkInterval.String()
+	check.Timeout = c.checkTimeout.String()
+	return check
+}
+
+func checkID(service *Service) string {
+	return "service:" + service.InstanceID()
+}
+
+// startHeartbeat reports the probe state of httpbin to the ttl check every check
+// interval until the service is deregistered.
+func (c *ConsulServiceRegistry) startHeartbeat(service *Service) {
+	if c.checkType != options.ConsulCheckTypeTtl {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	c.mutex.Lock()
+	if stop, ok := c.heartbeats[service.InstanceID()]; ok {
+		stop()
+	}
+	c.heartbeats[service.InstanceID()] = cancel
+	c.mutex.Unlock()
+	go func() {
+		ticker := time.NewTicker(c.checkInterval)
+		defer ticker.Stop()
+		for {
+			c.heartbeat(ctx, service)
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (c *ConsulServiceRegistry) heartbeat(ctx context.Context, service *Service) {
+	status, output := consulapi.HealthPassing, "httpbin is ready"
+	switch {
+	case !probe.IsLive():
+		status, output = consulapi.HealthCritical, "httpbin is not live"
+	case !probe.IsStarted():
+		status, output = consulapi.HealthWarning, "httpbin is not started"
+	case !probe.IsReady():
+		status, output = consulapi.HealthWarning, "httpbin is not ready"
+	}
+	err := c.client.Agent().UpdateTTLOpts(checkID(service), output, status, (&consulapi.QueryOptions{}).WithContext(ctx))
+	if err != nil && ctx.Err() == nil {
+		logs.Errorf("update ttl of service %s error:%v", service.InstanceID(), err)
+	}
+}
+
+func (c *ConsulServiceRegistry) stopHeartbeat(service *Service) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if stop, ok := c.heartbeats[service.InstanceID()]; ok {
+		stop()
+		delete(c.heartbeats, service.InstanceID())
+	}
+}
+
+func (c *ConsulServiceRegistry) DeregisterService(ctx context.Context, service *Service) error {
+	logs.Infof("start deregistry service:%+v", service)
+	c.stopHeartbeat(service)
+	return c.client.Agent().ServiceDeregisterOpts(service.InstanceID(), (&consulapi.QueryOptions{}).WithContext(ctx))
+}
+
+func (c *ConsulServiceRegistry) UpdateMetadata(ctx context.Context, service *Service, meta map[string]string) error {
+	// Registering an existing service ID again replaces its definition.
+	service.ServiceMeta = service.MergeMeta(meta)
+	return c.doRegistry(service)
+}
+
+func (c *ConsulServiceRegistry) GetInstances(ctx context.Context, serviceName string) ([]*Service, error) {
+	entries, _, err := c.client.Health().Service(serviceName, "", true, (&consulapi.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	services := make([]*Service, 0, len(entries))
+	for _, entry := range entries {
+		ip := entry.Service.Address
+		if len(ip) == 0 {
+			ip = entry.Node.Address
+		}
+		services = append(services, &Service{
+			ID:           entry.Service.ID,
+			ServiceName:  entry.Service.Service,
+			InstanceName: entry.Service.ID,
+			NodeName:     entry.Node.Node,
+			Ip:           ip,
+			Port:         strconv.Itoa(entry.Service.Port),
+			Protocol:     ServiceProtocol(entry.Service.Meta[MetaProtocol]),
+			ServiceTags:  entry.Service.Tags,
+			ServiceMeta:  entry.Service.Meta,
+		})
+	}
+	return services, nil
+}
+
+func (c *ConsulServiceRegistry) Close() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for id, stop := range c.heartbeats {
+		stop()
+		delete(c.heartbeats, id)
+	}
+	return nil
+}
+
 func NewConsulServiceRegistry(option *options.Option) (ServiceRegistry, error) {
 	config := consulapi.DefaultConfig()
 	config.Token = option.ConsulAuthToken
@@ -83,7 +226,12 @@ func NewConsulServiceRegistry(option *options.Option) (ServiceRegistry, error) {
 		return nil, err
 	}
 	registry := &ConsulServiceRegistry{
-		client: client,
+		client:          client,
+		checkType:       option.ConsulCheckType,
+		checkInterval:   option.ConsulCheckInterval,
+		checkTimeout:    option.ConsulCheckTimeout,
+		deregisterAfter: option.ConsulDeregister,
+		heartbeats:      make(map[string]context.CancelFunc),
 	}
 	return registry, nil
 }
@@ -0,0 +1,236 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"go.etcd.io/etcd/client/pkg/v3/transport"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"httpbin/pkg/logs"
+	"httpbin/pkg/options"
+)
+
+const (
+	DefaultEtcdTimeout     = time.Second * 5
+	DefaultEtcdDialTimeout = time.Second * 5
+)
+
+type etcdRegistration struct {
+	service *Service
+	leaseID clientv3.LeaseID
+	cancel  context.CancelFunc
+}
+
+// EtcdServiceRegistry writes each service as JSON under prefix/serviceName/instanceID
+// bound to a lease, and keeps the lease alive until the service is deregistered.
+type EtcdServiceRegistry struct {
+	client        *clientv3.Client
+	prefix        string
+	ttl           int64
+	mutex         sync.Mutex
+	registrations map[string]*etcdRegistration
+}
+
+func (c *EtcdServiceRegistry) RegisterService(ctx context.Context, service *Service) error {
+	leaseID, err := c.doRegistry(ctx, service, clientv3.NoLease)
+	if err != nil {
+		logs.Errorf("registry service %+v, error:%v", service, err)
+		ticker := time.NewTicker(DefaultEtcdTimeout)
+		defer ticker.Stop()
+	retry:
+		for {
+			select {
+			case <-ticker.C:
+				leaseID, err = c.doRegistry(ctx, service, clientv3.NoLease)
+				if err == nil {
+					break retry
+				}
+				logs.Errorf("registry service %+v, error:%v", service, err)
+			case <-ctx.Done():
+				logs.Infof("ctx.Done()")
+				return err
+			}
+		}
+	}
+
+	// The keepalive outlives ctx, which only bounds the registration itself.
+	keepAliveCtx, cancel := context.WithCancel(context.Background())
+	c.mutex.Lock()
+	c.registrations[c.key(service)] = &etcdRegistration{service: service, leaseID: leaseID, cancel: cancel}
+	c.mutex.Unlock()
+	go c.keepAlive(keepAliveCtx, service, leaseID)
+	return nil
+}
+
+// doRegistry puts service under a lease. A new lease is granted when leaseID is NoLease.
+func (c *EtcdServiceRegistry) doRegistry(ctx context.Context, service *Service, leaseID clientv3.LeaseID) (clientv3.LeaseID, error) {
+	logs.Infof("start registry service:%+v", service)
+	c.mutex.Lock()
+	value, err := json.Marshal(service)
+	c.mutex.Unlock()
+	if err != nil {
+		return clientv3.NoLease, err
+	}
+	return c.put(ctx, service, value, leaseID)
+}
+
+// put writes value of service under leaseID, granting a new lease when it is NoLease.
+func (c *EtcdServiceRegistry) put(ctx context.Context, service *Service, value []byte, leaseID clientv3.LeaseID) (clientv3.LeaseID, error) {
+	if leaseID == clientv3.NoLease {
+		lease, err := c.client.Grant(ctx, c.ttl)
+		if err != nil {
+			return clientv3.NoLease, err
+		}
+		leaseID = lease.ID
+	}
+	if _, err := c.client.Put(ctx, c.key(service), string(value), clientv3.WithLease(leaseID)); err != nil {
+		return clientv3.NoLease, err
+	}
+	return leaseID, nil
+}
+
+// keepAlive keeps the lease alive until ctx is cancelled, and registers the
+// service again under a new lease whenever the lease is lost.
+func (c *EtcdServiceRegistry) keepAlive(ctx context.Context, service *Service, leaseID clientv3.LeaseID) {
+	for {
+		ch, err := c.client.KeepAlive(ctx, leaseID)
+		if err == nil {
+			for range ch {
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		logs.Warnf("etcd lease %x of service %s lost, registry again", leaseID, c.key(service))
+		for {
+			registerCtx, cancel := context.WithTimeout(ctx, DefaultEtcdTimeout)
+			leaseID, err = c.doRegistry(registerCtx, service, clientv3.NoLease)
+			cancel()
+			if err == nil {
+				break
+			}
+			logs.Errorf("registry service %+v, error:%v", service, err)
+			select {
+			case <-time.After(DefaultEtcdTimeout):
+			case <-ctx.Done():
+				return
+			}
+		}
+		c.mutex.Lock()
+		if registration, ok := c.registrations[c.key(service)]; ok {
+			registration.leaseID = leaseID
+		}
+		c.mutex.Unlock()
+	}
+}
+
+func (c *EtcdServiceRegistry) DeregisterService(ctx context.Context, service *Service) error {
+	logs.Infof("start deregistry service:%+v", service)
+	key := c.key(service)
+	c.mutex.Lock()
+	registration, ok := c.registrations[key]
+	delete(c.registrations, key)
+	c.mutex.Unlock()
+	if ok {
+		registration.cancel()
+	}
+	if _, err := c.client.Delete(ctx, key); err != nil {
+		return err
+	}
+	if ok {
+		if _, err := c.client.Revoke(ctx, registration.leaseID); err != nil {
+			logs.Warnf("revoke etcd lease %x error:%v", registration.leaseID, err)
+		}
+	}
+	return nil
+}
+
+func (c *EtcdServiceRegistry) UpdateMetadata(ctx context.Context, service *Service, meta map[string]string) error {
+	logs.Infof("start update service:%+v metadata:%v", service, meta)
+	// Only the update of the service is locked, a slow put must not block the
+	// deregistration and the registry again of the keepalive.
+	c.mutex.Lock()
+	registration, ok := c.registrations[c.key(service)]
+	if !ok {
+		c.mutex.Unlock()
+		// A put without the lease would never expire.
+		return fmt.Errorf("service %s is not registered", service.InstanceID())
+	}
+	service.ServiceMeta = service.MergeMeta(meta)
+	value, err := json.Marshal(service)
+	leaseID := registration.leaseID
+	c.mutex.Unlock()
+	if err != nil {
+		return err
+	}
+	_, err = c.put(ctx, service, value, leaseID)
+	return err
+}
+
+func (c *EtcdServiceRegistry) GetInstances(ctx context.Context, serviceName string) ([]*Service, error) {
+	resp, err := c.client.Get(ctx, path.Join(c.prefix, serviceName)+"/", clientv3.WithPrefix())
+	if err != nil {
+		return nil, err
+	}
+	services := make([]*Service, 0, len(resp.Kvs))
+	for _, kv := range resp.Kvs {
+		service := &Service{}
+		if err := json.Unmarshal(kv.Value, service); err != nil {
+			logs.Warnf("unmarshal etcd service %s error:%v", kv.Key, err)
+			continue
+		}
+		services = append(services, service)
+	}
+	return services, nil
+}
+
+func (c *EtcdServiceRegistry) Close() error {
+	c.mutex.Lock()
+	for key, registration := range c.registrations {
+		registration.cancel()
+		delete(c.registrations, key)
+	}
+	c.mutex.Unlock()
+	return c.client.Close()
+}
+
+func (c *EtcdServiceRegistry) key(service *Service) string {
+	return path.Join(c.prefix, service.ServiceName, service.InstanceID())
+}
+
+func NewEtcdServiceRegistry(option *options.Option) (ServiceRegistry, error) {
+	config := clientv3.Config{
+		Endpoints:   strings.Split(option.EtcdEndpoints, ","),
+		DialTimeout: DefaultEtcdDialTimeout,
+		Username:    option.EtcdUsername,
+		Password:    option.EtcdPassword,
+	}
+	if len(option.EtcdCACertFile) > 0 || len(option.EtcdCertFile) > 0 {
+		tlsInfo := transport.TLSInfo{
+			TrustedCAFile: option.EtcdCACertFile,
+			CertFile:      option.EtcdCertFile,
+			KeyFile:       option.EtcdKeyFile,
+		}
+		tlsConfig, err := tlsInfo.ClientConfig()
+		if err != nil {
+			return nil, err
+		}
+		config.TLS = tlsConfig
+	}
+	client, err := clientv3.New(config)
+	if err != nil {
+		return nil, err
+	}
+	registry := &EtcdServiceRegistry{
+		client:        client,
+		prefix:        option.EtcdPrefix,
+		ttl:           option.EtcdTTL,
+		registrations: make(map[string]*etcdRegistration),
+	}
+	return registry, nil
+}
@@ -0,0 +1,166 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+	"httpbin/pkg/options"
+)
+
+// startEtcd starts an embedded single member etcd and returns its client url.
+func startEtcd(t *testing.T) string {
+	t.Helper()
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL, peerURL := freeURL(t), freeURL(t)
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{clientURL}, []url.URL{clientURL}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{peerURL}, []url.URL{peerURL}
+	cfg.InitialCluster = cfg.InitialClusterFromName(cfg.Name)
+	etcd, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("start etcd: %v", err)
+	}
+	t.Cleanup(etcd.Close)
+	select {
+	case <-etcd.Server.ReadyNotify():
+	case <-time.After(30 * time.Second):
+		t.Fatal("etcd not ready")
+	}
+	return clientURL.String()
+}
+
+func freeURL(t *testing.T) url.URL {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return url.URL{Scheme: "http", Host: lis.Addr().String()}
+}
+
+// getService returns the service registered under key, nil if there is none.
+func getService(t *testing.T, client *clientv3.Client, key string) (*Service, clientv3.LeaseID) {
+	t.Helper()
+	resp, err := client.Get(context.Background(), key)
+	if err != nil {
+		t.Fatalf("get %s: %v", key, err)
+	}
+	if len(resp.Kvs) == 0 {
+		return nil, clientv3.NoLease
+	}
+	service := &Service{}
+	if err := json.Unmarshal(resp.Kvs[0].Value, service); err != nil {
+		t.Fatalf("unmarshal %s: %v", resp.Kvs[0].Value, err)
+	}
+	return service, clientv3.LeaseID(resp.Kvs[0].Lease)
+}
+
+func waitFor(t *testing.T, timeout time.Duration, condition func() bool, format string, args ...interface{}) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf(format, args...)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func TestEtcdServiceRegistry(t *testing.T) {
+	endpoint := startEtcd(t)
+	option := &options.Option{EtcdEndpoints: endpoint, EtcdPrefix: "/httpbin/services", EtcdTTL: 2}
+	serviceRegistry, err := NewEtcdServiceRegistry(option)
+	if err != nil {
+		t.Fatalf("new etcd registry: %v", err)
+	}
+	defer serviceRegistry.Close()
+	client, err := clientv3.New(clientv3.Config{Endpoints: []string{endpoint}, DialTimeout: DefaultEtcdDialTimeout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	service := &Service{
+		ServiceName:  "httpbin",
+		InstanceName: "httpbin-0",
+		Ip:           "10.0.0.1",
+		Port:         "80",
+		Protocol:     Http,
+		ServiceMeta:  map[string]string{"version": "v1"},
+	}
+	key := fmt.Sprintf("/httpbin/services/httpbin/%s", service.InstanceID())
+
+	// register
+	if err := serviceRegistry.RegisterService(ctx, service); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	registered, leaseID := getService(t, client, key)
+	if registered == nil || registered.Ip != service.Ip || registered.Port != service.Port {
+		t.Fatalf("registered service = %+v, want %+v", registered, service)
+	}
+	if leaseID == clientv3.NoLease {
+		t.Fatal("service registered without lease")
+	}
+	instances, err := serviceRegistry.(ServiceDiscovery).GetInstances(ctx, "httpbin")
+	if err != nil || len(instances) != 1 {
+		t.Fatalf("instances = %v, %v, want 1 instance", instances, err)
+	}
+
+	// the keepalive holds the service beyond the ttl
+	time.Sleep(3 * time.Second)
+	if registered, _ := getService(t, client, key); registered == nil {
+		t.Fatal("service expired while kept alive")
+	}
+
+	// lease expiry registers again under a new lease
+	if _, err := client.Revoke(ctx, leaseID); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+	var newLeaseID clientv3.LeaseID
+	waitFor(t, 15*time.Second, func() bool {
+		registered, newLeaseID = getService(t, client, key)
+		return registered != nil && newLeaseID != leaseID
+	}, "service not registered again after lease expiry")
+
+	// UpdateMetadata merges into the metadata and keeps the lease
+	if err := serviceRegistry.UpdateMetadata(ctx, service, map[string]string{"zone": "a"}); err != nil {
+		t.Fatalf("update metadata: %v", err)
+	}
+	registered, leaseID = getService(t, client, key)
+	if registered.ServiceMeta["version"] != "v1" || registered.ServiceMeta["zone"] != "a" {
+		t.Fatalf("metadata = %v, want version=v1 and zone=a", registered.ServiceMeta)
+	}
+	if leaseID != newLeaseID {
+		t.Fatalf("lease after update = %x, want %x", leaseID, newLeaseID)
+	}
+
+	// deregister deletes the service and stops the keepalive
+	if err := serviceRegistry.DeregisterService(ctx, service); err != nil {
+		t.Fatalf("deregister: %v", err)
+	}
+	if registered, _ := getService(t, client, key); registered != nil {
+		t.Fatalf("service %+v still registered", registered)
+	}
+	time.Sleep(time.Second)
+	if registered, _ := getService(t, client, key); registered != nil {
+		t.Fatalf("service %+v registered again after deregister", registered)
+	}
+
+	// UpdateMetadata of a deregistered service fails instead of putting it without lease
+	if err := serviceRegistry.UpdateMetadata(ctx, service, map[string]string{"zone": "b"}); err == nil {
+		t.Fatal("update metadata of a deregistered service succeeded")
+	}
+	if registered, _ := getService(t, client, key); registered != nil {
+		t.Fatalf("service %+v registered again by update metadata", registered)
+	}
+}
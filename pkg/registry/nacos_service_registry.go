@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/nacos-group/nacos-sdk-go/v2/clients"
@@ -14,6 +15,7 @@ import (
 	"github.com/nacos-group/nacos-sdk-go/v2/vo"
 	"httpbin/pkg/logs"
 	"httpbin/pkg/options"
+	"httpbin/pkg/probe"
 )
 
 const (
@@ -41,23 +43,34 @@ type NacosServiceRegistry struct {
 	nacosServerPort   int
 	nacosUsername     string
 	nacosPassword     string
+	weight            float64
+	clusterName       string
+	ephemeral         bool
+	metadata          map[string]string
+	mutex             sync.Mutex
+	services          map[string]*Service
+	// syncMutex orders the probe syncs with the deregistrations, so a sync running in the
+	// probe goroutine never registers a deregistered instance again.
+	syncMutex sync.Mutex
 }
 
 func (c *NacosServiceRegistry) RegisterService(ctx context.Context, service *Service) error {
 	err := c.doRegistry(service)
 	if err == nil {
+		c.addService(service)
 		return nil
 	}
 	logs.Errorf("registry service %+v, error:%v", service, err)
 	stop := make(chan struct{})
 	go func() {
-		ticker := time.NewTicker(time.Duration(DefaultNacosTimeout))
+		ticker := time.NewTicker(time.Duration(DefaultNacosTimeout) * time.Millisecond)
 		defer ticker.Stop()
 		for {
 			select {
 			case <-ticker.C:
 				err = c.doRegistry(service)
 				if err == nil {
+					c.addService(service)
 					close(stop)
 					return
 				} else {
@@ -75,27 +88,192 @@ func (c *NacosServiceRegistry) RegisterService(ctx context.Context, service *Ser
 	return err
 }
 
+// doRegistry registers service, or updates it when already registered, with
+// Enable and Healthy following the readiness of httpbin. A nacos 2.x client keeps one
+// ephemeral instance per service name and group, so ephemeral endpoints are registered
+// in one batch with the other registered endpoints, or else each endpoint would replace
+// the previous one, also in the redo cache of the client.
 func (c *NacosServiceRegistry) doRegistry(service *Service) error {
 	logs.Infof("start registry service:%+v", service)
+	if !c.ephemeral {
+		return c.updateInstance(service)
+	}
+	services := []*Service{service}
+	for _, registered := range c.registeredServices() {
+		if registered.InstanceID() != service.InstanceID() && registered.ServiceName == service.ServiceName {
+			services = append(services, registered)
+		}
+	}
+	return c.batchRegister(service.ServiceName, services)
+}
+
+func (c *NacosServiceRegistry) updateInstance(service *Service) error {
+	instance := c.instance(service)
+	success, err := c.namingClient.UpdateInstance(vo.UpdateInstanceParam{
+		Ip:          instance.Ip,
+		Port:        instance.Port,
+		ServiceName: service.ServiceName,
+		GroupName:   c.nacosGroupName,
+		ClusterName: instance.ClusterName,
+		Weight:      instance.Weight,
+		Enable:      instance.Enable,
+		Healthy:     instance.Healthy,
+		Ephemeral:   instance.Ephemeral,
+		Metadata:    instance.Metadata,
+	})
+	if !success || err != nil {
+		return errors.New(fmt.Sprintf("registry error:%v", err))
+	}
+	return nil
+}
+
+// batchRegister replaces the ephemeral instances of serviceName registered by this client
+// with services.
+func (c *NacosServiceRegistry) batchRegister(serviceName string, services []*Service) error {
+	instances := make([]vo.RegisterInstanceParam, 0, len(services))
+	for _, service := range services {
+		instances = append(instances, c.instance(service))
+	}
+	success, err := c.namingClient.BatchRegisterInstance(vo.BatchRegisterInstanceParam{
+		ServiceName: serviceName,
+		GroupName:   c.nacosGroupName,
+		Instances:   instances,
+	})
+	if !success || err != nil {
+		return errors.New(fmt.Sprintf("registry error:%v", err))
+	}
+	return nil
+}
+
+func (c *NacosServiceRegistry) instance(service *Service) vo.RegisterInstanceParam {
+	port, _ := strconv.Atoi(service.Port)
+	state := probe.Current()
+	return vo.RegisterInstanceParam{
+		Ip:          service.Ip,
+		Port:        uint64(port),
+		ServiceName: service.ServiceName,
+		GroupName:   c.nacosGroupName,
+		ClusterName: c.clusterName,
+		Weight:      c.weight,
+		Enable:      state.Ready,
+		Healthy:     state.Live && state.Ready,
+		Ephemeral:   c.ephemeral,
+		Metadata:    service.MergeMeta(c.metadata),
+	}
+}
+
+func (c *NacosServiceRegistry) addService(service *Service) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.services[service.InstanceID()] = service
+}
+
+func (c *NacosServiceRegistry) registeredServices() []*Service {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	services := make([]*Service, 0, len(c.services))
+	for _, service := range c.services {
+		services = append(services, service)
+	}
+	return services
+}
+
+// onProbeChange syncs the Enable and Healthy flags of registered instances with the probe state.
+func (c *NacosServiceRegistry) onProbeChange(state probe.State) {
+	c.syncMutex.Lock()
+	defer c.syncMutex.Unlock()
+	services := c.registeredServices()
+	if c.ephemeral {
+		byName := make(map[string][]*Service)
+		for _, service := range services {
+			byName[service.ServiceName] = append(byName[service.ServiceName], service)
+		}
+		for serviceName, services := range byName {
+			if err := c.batchRegister(serviceName, services); err != nil {
+				logs.Errorf("sync service %s with probe state %+v error:%v", serviceName, state, err)
+			}
+		}
+		return
+	}
+	for _, service := range services {
+		if err := c.updateInstance(service); err != nil {
+			logs.Errorf("sync service %s with probe state %+v error:%v", service.InstanceID(), state, err)
+		}
+	}
+}
+
+// DeregisterService deregisters service. The other ephemeral endpoints of the service name
+// are registered again as a batch without it, since deregistering an instance removes the
+// whole batch of the client.
+func (c *NacosServiceRegistry) DeregisterService(ctx context.Context, service *Service) error {
+	logs.Infof("start deregistry service:%+v", service)
+	c.syncMutex.Lock()
+	defer c.syncMutex.Unlock()
+	c.mutex.Lock()
+	delete(c.services, service.InstanceID())
+	c.mutex.Unlock()
+	if c.ephemeral {
+		var remaining []*Service
+		for _, registered := range c.registeredServices() {
+			if registered.ServiceName == service.ServiceName {
+				remaining = append(remaining, registered)
+			}
+		}
+		if len(remaining) > 0 {
+			return c.batchRegister(service.ServiceName, remaining)
+		}
+	}
 	port, _ := strconv.Atoi(service.Port)
-	registration := vo.RegisterInstanceParam{
+	deregistration := vo.DeregisterInstanceParam{
 		Ip:          service.Ip,
 		Port:        uint64(port),
 		ServiceName: service.ServiceName,
 		GroupName:   c.nacosGroupName,
-		Weight:      10,
-		Enable:      true,
-		Healthy:     true,
-		Ephemeral:   true,
-		Metadata:    service.ServiceMeta,
+		Cluster:     c.clusterName,
+		Ephemeral:   c.ephemeral,
 	}
-	success, err := c.namingClient.RegisterInstance(registration)
+	success, err := c.namingClient.DeregisterInstance(deregistration)
 	if !success || err != nil {
-		return errors.New(fmt.Sprintf("registry error:%v", err))
+		return errors.New(fmt.Sprintf("deregistry error:%v", err))
 	}
 	return nil
 }
 
+func (c *NacosServiceRegistry) UpdateMetadata(ctx context.Context, service *Service, meta map[string]string) error {
+	logs.Infof("start update service:%+v metadata:%v", service, meta)
+	service.ServiceMeta = service.MergeMeta(meta)
+	return c.doRegistry(service)
+}
+
+func (c *NacosServiceRegistry) GetInstances(ctx context.Context, serviceName string) ([]*Service, error) {
+	instances, err := c.namingClient.SelectInstances(vo.SelectInstancesParam{
+		ServiceName: serviceName,
+		GroupName:   c.nacosGroupName,
+		HealthyOnly: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	services := make([]*Service, 0, len(instances))
+	for _, instance := range instances {
+		services = append(services, &Service{
+			ID:           instance.InstanceId,
+			ServiceName:  serviceName,
+			InstanceName: instance.InstanceId,
+			Ip:           instance.Ip,
+			Port:         strconv.FormatUint(instance.Port, 10),
+			Protocol:     ServiceProtocol(instance.Metadata[MetaProtocol]),
+			ServiceMeta:  instance.Metadata,
+		})
+	}
+	return services, nil
+}
+
+func (c *NacosServiceRegistry) Close() error {
+	c.namingClient.CloseClient()
+	return nil
+}
+
 func NewNacosServiceRegistry(option *options.Option) (ServiceRegistry, error) {
 
 	nacosClietConfig := constant.NewClientConfig(
@@ -141,6 +319,12 @@ func NewNacosServiceRegistry(option *options.Option) (ServiceRegistry, error) {
 		nacosServerPort:   nacosServerPort,
 		nacosUsername:     option.NacosUsername,
 		nacosPassword:     option.NacosPassword,
+		weight:            option.NacosWeight,
+		clusterName:       option.NacosClusterName,
+		ephemeral:         option.NacosEphemeral,
+		metadata:          option.NacosMetadata,
+		services:          make(map[string]*Service),
 	}
+	probe.Subscribe(registry.onProbeChange)
 	return registry, nil
 }
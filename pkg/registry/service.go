@@ -2,45 +2,99 @@ package registry
 
 import (
 	"fmt"
-	"strings"
 
 	"github.com/google/uuid"
 	"httpbin/pkg/options"
+	"httpbin/pkg/utils"
 )
 
 type ServiceProtocol string
 
 const (
-	Http ServiceProtocol = "http"
-	Grpc ServiceProtocol = "grpc"
+	Http  ServiceProtocol = "http"
+	Https ServiceProtocol = "https"
+	Grpc  ServiceProtocol = "grpc"
+	Grpcs ServiceProtocol = "grpcs"
+)
+
+const (
+	MetaProtocol = "protocol"
+	MetaMTLS     = "mtls"
+	TagMTLS      = "mtls"
 )
 
 type Service struct {
-	ID           string
-	ServiceName  string
-	InstanceName string
-	NodeName     string
-	Ip           string
-	Port         string
-	Protocol     ServiceProtocol
-	ServiceTags  []string
-	ServiceMeta  map[string]string
-	CheckPath    string
+	ID           string            `json:"id"`
+	ServiceName  string            `json:"serviceName"`
+	InstanceName string            `json:"instanceName"`
+	NodeName     string            `json:"nodeName"`
+	Ip           string            `json:"ip"`
+	Port         string            `json:"port"`
+	Protocol     ServiceProtocol   `json:"protocol"`
+	ServiceTags  []string          `json:"serviceTags"`
+	ServiceMeta  map[string]string `json:"serviceMeta"`
+	CheckPath    string            `json:"checkPath"`
+	MTLS         bool              `json:"mtls"`
+}
+
+// InstanceID returns a registry unique instance id, one per protocol endpoint.
+func (s *Service) InstanceID() string {
+	if s.Protocol == Http {
+		return s.InstanceName
+	}
+	return s.InstanceName + "-" + string(s.Protocol)
+}
+
+// MergeMeta returns a copy of the service metadata with meta merged in.
+func (s *Service) MergeMeta(meta map[string]string) map[string]string {
+	merged := make(map[string]string, len(s.ServiceMeta)+len(meta))
+	for k, v := range s.ServiceMeta {
+		merged[k] = v
+	}
+	for k, v := range meta {
+		merged[k] = v
+	}
+	return merged
+}
+
+// NewServicesFromOption returns one service instance per endpoint httpbin serves:
+// http on ServerPort, grpc on GrpcPort, grpc with tls on GrpcTlsPort and https on HttpsPort
+// when enabled.
+func NewServicesFromOption(option *options.Option) ([]*Service, error) {
+	services := []*Service{newService(option, Http, option.ServerPort, false)}
+	if option.GrpcEnable && option.GrpcPlaintext() {
+		services = append(services, newService(option, Grpc, option.GrpcPort, false))
+	}
+	if option.GrpcEnable && option.GrpcTls {
+		services = append(services, newService(option, Grpcs, option.GrpcTlsPort, option.MTLS))
+	}
+	if option.HttpsEnable {
+		services = append(services, newService(option, Https, option.HttpsPort, option.MTLS))
+	}
+	return services, nil
 }
 
-func NewServiceFromOption(option *options.Option) (*Service, error) {
+func newService(option *options.Option, protocol ServiceProtocol, port uint32, mtls bool) *Service {
 	uuid, _ := uuid.NewUUID()
+	tags := append(utils.SplitNonEmpty(option.ServiceTags, ","), string(protocol))
+	meta := map[string]string{MetaProtocol: string(protocol)}
+	if mtls {
+		tags = append(tags, TagMTLS)
+		meta[MetaMTLS] = "true"
+	}
 	service := &Service{
 		ID:           uuid.String(),
 		ServiceName:  option.ServiceName,
 		InstanceName: option.InstanceName,
 		NodeName:     option.NodeName,
-		Port:         fmt.Sprintf("%d", option.ServerPort),
+		Port:         fmt.Sprintf("%d", port),
 		Ip:           option.ServerIp,
-		ServiceTags:  strings.Split(option.ServiceTags, ","),
+		ServiceTags:  tags,
 		ServiceMeta:  option.ServiceMeta,
 		CheckPath:    option.ServiceCheckPath,
-		Protocol:     Http,
+		Protocol:     protocol,
+		MTLS:         mtls,
 	}
-	return service, nil
+	service.ServiceMeta = service.MergeMeta(meta)
+	return service
 }
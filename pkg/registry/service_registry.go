@@ -3,6 +3,8 @@ package registry
 import (
 	"context"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"httpbin/pkg/logs"
@@ -10,7 +12,19 @@ import (
 )
 
 type ServiceRegistry interface {
+	// RegisterService registers service, retrying until ctx is done.
 	RegisterService(ctx context.Context, service *Service) error
+	// DeregisterService removes service from the registry.
+	DeregisterService(ctx context.Context, service *Service) error
+	// UpdateMetadata merges meta into the metadata of a registered service.
+	UpdateMetadata(ctx context.Context, service *Service, meta map[string]string) error
+	// Close releases the registry client.
+	Close() error
+}
+
+// ServiceDiscovery lists healthy instances of a service from the registry.
+type ServiceDiscovery interface {
+	GetInstances(ctx context.Context, serviceName string) ([]*Service, error)
 }
 
 func ServiceRegistryFactory(option *options.Option) (ServiceRegistry, error) {
@@ -21,26 +35,64 @@ func ServiceRegistryFactory(option *options.Option) (ServiceRegistry, error) {
 	case options.ServiceRegistryTypeNacos:
 		serviceRegistry, err := NewNacosServiceRegistry(option)
 		return serviceRegistry, err
+	case options.ServiceRegistryTypeEtcd:
+		serviceRegistry, err := NewEtcdServiceRegistry(option)
+		return serviceRegistry, err
 	default:
 		return nil, errors.New("not support registry type")
 	}
 }
 
-func StartRegistry(ctx context.Context, option *options.Option) {
+// deregisterTimeout bounds the deregistration on shutdown within the shutdown deadline,
+// leaving the rest of it to drain the listeners.
+const deregisterTimeout = 10 * time.Second
+
+// NewServiceRegistry returns the registry of --registry-type, nil when registry is disabled.
+func NewServiceRegistry(option *options.Option) (ServiceRegistry, error) {
 	if option.RegistryType == options.ServiceRegistryTypeNone {
-		return
+		return nil, nil
 	}
-	if serviceRegistry, err := ServiceRegistryFactory(option); err != nil {
-		logs.Fatalf("service registry get error:%v", err)
-	} else {
-		service, _ := NewServiceFromOption(option)
-		newCtx, cancel := context.WithTimeout(ctx, time.Second*30)
-		defer cancel()
-		err = serviceRegistry.RegisterService(newCtx, service)
-		if err != nil {
-			logs.Fatalf("service registry error:%v", err)
+	serviceRegistry, err := ServiceRegistryFactory(option)
+	if err != nil {
+		return nil, fmt.Errorf("service registry get error:%v", err)
+	}
+	return serviceRegistry, nil
+}
+
+// StartRegistry registers every endpoint of this instance and returns the services so the
+// caller can deregister them on shutdown. Call it once all listeners are created.
+func StartRegistry(ctx context.Context, option *options.Option, serviceRegistry ServiceRegistry) ([]*Service, error) {
+	services, _ := NewServicesFromOption(option)
+	newCtx, cancel := context.WithTimeout(ctx, time.Second*30)
+	defer cancel()
+	for i, service := range services {
+		if err := serviceRegistry.RegisterService(newCtx, service); err != nil {
+			// Do not leave the endpoints registered so far behind the failed start.
+			if stopErr := StopRegistry(context.Background(), serviceRegistry, services[:i]); stopErr != nil {
+				logs.Errorf("stop service registry error:%v", stopErr)
+			}
+			return nil, fmt.Errorf("service registry error:%v", err)
 		}
-		return
 	}
-	return
+	return services, nil
+}
+
+// StopRegistry deregisters services within deregisterTimeout and ctx, and closes the
+// registry client.
+func StopRegistry(ctx context.Context, serviceRegistry ServiceRegistry, services []*Service) error {
+	ctx, cancel := context.WithTimeout(ctx, deregisterTimeout)
+	defer cancel()
+	var errs []string
+	for _, service := range services {
+		if err := serviceRegistry.DeregisterService(ctx, service); err != nil {
+			errs = append(errs, fmt.Sprintf("deregistry service %s:%s error:%v", service.Protocol, service.Port, err))
+		}
+	}
+	if err := serviceRegistry.Close(); err != nil {
+		errs = append(errs, fmt.Sprintf("close service registry error:%v", err))
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
 }
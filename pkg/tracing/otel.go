@@ -0,0 +1,294 @@
+package tracing
+
+import (
+	"context"
+	"encoding/binary"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"httpbin/pkg/logs"
+	"httpbin/pkg/options"
+)
+
+const otelInstrumentationName = "httpbin"
+
+// grpcCodes maps the names of the grpc status codes to the codes.
+var grpcCodes = map[string]codes.Code{}
+
+func init() {
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		grpcCodes[c.String()] = c
+	}
+}
+
+// OtelTracer exports spans over OTLP to --otel-endpoint, propagating W3C baggage, and
+// traceparent and tracestate without --trace-inject. The OTEL_EXPORTER_OTLP_* envs apply
+// without --otel-endpoint. The spans are also exported to the recorder. The server spans
+// are sampled by the Sampler, the other spans follow their parents.
+type OtelTracer struct {
+	serviceName string
+	provider    *sdktrace.TracerProvider
+	tracer      trace.Tracer
+	propagation *Propagation
+	propagator  propagation.TextMapPropagator
+	sampler     *Sampler
+}
+
+func NewOtelTracer(ctx context.Context, option *options.Option, recorder *Recorder) (Tracer, error) {
+	p, err := NewPropagation(option, options.PropagationW3C)
+	if err != nil {
+		return nil, err
+	}
+	sampler, err := NewSampler(option)
+	if err != nil {
+		return nil, err
+	}
+	exporter, err := newOtelExporter(ctx, option)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(option.ServiceName),
+		semconv.ServiceInstanceID(option.InstanceName),
+		semconv.ServiceNamespace(option.NameSpace),
+		semconv.ServiceVersion(option.Version),
+		semconv.K8SNodeName(option.NodeName),
+	))
+	if err != nil {
+		return nil, err
+	}
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(otelSampler{sdktrace.ParentBased(sdktrace.TraceIDRatioBased(option.SamplingRate))}),
+	}
+	if recorder != nil {
+		opts = append(opts, sdktrace.WithSyncer(otelRecorder{recorder}))
+	}
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logs.Errorf("opentelemetry error: %v", err)
+	}))
+	return &OtelTracer{
+		serviceName: option.ServiceName,
+		provider:    provider,
+		tracer:      provider.Tracer(otelInstrumentationName),
+		propagation: p,
+		propagator:  propagation.NewCompositeTextMapPropagator(otelPropagator{p}, propagation.Baggage{}),
+		sampler:     sampler,
+	}, nil
+}
+
+func newOtelExporter(ctx context.Context, option *options.Option) (*otlptrace.Exporter, error) {
+	if option.OtelProtocol == options.OtelProtocolHttp {
+		var opts []otlptracehttp.Option
+		if len(option.OtelEndpoint) > 0 {
+			opts = append(opts, otlptracehttp.WithEndpoint(option.OtelEndpoint))
+		}
+		if option.OtelInsecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		return otlptracehttp.New(ctx, opts...)
+	}
+	var opts []otlptracegrpc.Option
+	if len(option.OtelEndpoint) > 0 {
+		opts = append(opts, otlptracegrpc.WithEndpoint(option.OtelEndpoint))
+	}
+	if option.OtelInsecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	return otlptracegrpc.New(ctx, opts...)
+}
+
+func (t *OtelTracer) Middleware() gin.HandlerFunc {
+	middleware := otelgin.Middleware(t.serviceName,
+		otelgin.WithTracerProvider(t.provider),
+		otelgin.WithPropagators(t.propagator),
+		otelgin.WithFilter(func(r *http.Request) bool {
+			return !skipTrace(r)
+		}),
+	)
+	return func(c *gin.Context) {
+		if !skipTrace(c.Request) {
+			c.Request = c.Request.WithContext(t.sample(c.Request.Context(), c.FullPath(), headerCarrier(c.Request.Header)))
+		}
+		middleware(c)
+	}
+}
+
+func (t *OtelTracer) StartGrpcServerSpan(ctx context.Context, method string, md metadata.MD) (context.Context, Span) {
+	ctx = t.sample(ctx, method, metadataCarrier(md))
+	ctx = t.propagator.Extract(ctx, metadataCarrier(md))
+	ctx, span := t.tracer.Start(ctx, strings.TrimPrefix(method, "/"), trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(grpcAttributes(method)...))
+	return ctx, otelSpan{span}
+}
+
+// sample puts the sampling decision of a request to route into ctx for the otelSampler.
+func (t *OtelTracer) sample(ctx context.Context, route string, carrier Carrier) context.Context {
+	var upstream *SpanContext
+	if sc, ok := t.propagation.Extract(carrier); ok {
+		upstream = &sc
+	}
+	return contextWithSampling(ctx, t.sampler.Sample(route, carrier, upstream))
+}
+
+func (t *OtelTracer) StartHttpClientSpan(ctx context.Context, req *http.Request) Span {
+	ctx, span := t.tracer.Start(ctx, "HTTP "+req.Method, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPMethod(req.Method), semconv.HTTPURL(req.URL.String())))
+	t.propagator.Inject(ctx, headerCarrier(req.Header))
+	return otelSpan{span}
+}
+
+func (t *OtelTracer) StartGrpcClientSpan(ctx context.Context, address string, method string, md metadata.MD) Span {
+	ctx, span := t.tracer.Start(ctx, strings.TrimPrefix(method, "/"), trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(append(grpcAttributes(method), semconv.NetPeerName(address))...))
+	t.propagator.Inject(ctx, metadataCarrier(md))
+	return otelSpan{span}
+}
+
+func (t *OtelTracer) Shutdown(ctx context.Context) error {
+	return t.provider.Shutdown(ctx)
+}
+
+// otelSampler samples the server spans by the sampling decision of the request in the
+// context, and the other spans by next.
+type otelSampler struct {
+	next sdktrace.Sampler
+}
+
+func (s otelSampler) ShouldSample(p sdktrace.SamplingParameters) sdktrace.SamplingResult {
+	sampling := SamplingFromContext(p.ParentContext)
+	if sampling == nil || p.Kind != trace.SpanKindServer {
+		return s.next.ShouldSample(p)
+	}
+	result := sdktrace.SamplingResult{
+		Decision:   sdktrace.Drop,
+		Tracestate: trace.SpanContextFromContext(p.ParentContext).TraceState(),
+	}
+	if sampling.Sampled {
+		result.Decision = sdktrace.RecordAndSample
+	}
+	return result
+}
+
+func (s otelSampler) Description() string {
+	return "HttpbinSampler{" + s.next.Description() + "}"
+}
+
+func grpcAttributes(method string) []attribute.KeyValue {
+	service, name, _ := strings.Cut(strings.TrimPrefix(method, "/"), "/")
+	return []attribute.KeyValue{semconv.RPCSystemGRPC, semconv.RPCService(service), semconv.RPCMethod(name)}
+}
+
+type otelSpan struct {
+	span trace.Span
+}
+
+// Tag sets the tag as the attribute of the OpenTelemetry semantic conventions.
+func (s otelSpan) Tag(key string, value string) {
+	switch key {
+	case TagHTTPStatusCode:
+		if code, err := strconv.Atoi(value); err == nil {
+			s.span.SetAttributes(semconv.HTTPStatusCode(code))
+			if code >= http.StatusInternalServerError {
+				s.span.SetStatus(otelcodes.Error, http.StatusText(code))
+			}
+			return
+		}
+	case TagGRPCStatusCode:
+		if code, ok := grpcCodes[value]; ok {
+			s.span.SetAttributes(semconv.RPCGRPCStatusCodeKey.Int(int(code)))
+			return
+		}
+	case TagPeerAddress:
+		s.span.SetAttributes(semconv.NetSockPeerAddr(value))
+		return
+	}
+	s.span.SetAttributes(attribute.String(key, value))
+}
+
+func (s otelSpan) Error(message string) {
+	s.span.SetStatus(otelcodes.Error, message)
+}
+
+func (s otelSpan) End() {
+	s.span.End()
+}
+
+// otelPropagator is the propagation.TextMapPropagator of a Propagation.
+type otelPropagator struct {
+	propagation *Propagation
+}
+
+func (p otelPropagator) Inject(ctx context.Context, carrier propagation.TextMapCarrier) {
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		p.propagation.Inject(fromOtelSpanContext(sc), toCarrier(carrier))
+	}
+}
+
+func (p otelPropagator) Extract(ctx context.Context, carrier propagation.TextMapCarrier) context.Context {
+	if sc, ok := p.propagation.Extract(toCarrier(carrier)); ok {
+		return trace.ContextWithRemoteSpanContext(ctx, otelSpanContext(sc))
+	}
+	return ctx
+}
+
+func (p otelPropagator) Fields() []string {
+	return p.propagation.fields
+}
+
+// otelSpanContext returns the remote parent of sc, sampled if the sampling is deferred.
+func otelSpanContext(sc SpanContext) trace.SpanContext {
+	config := trace.SpanContextConfig{Remote: true}
+	binary.BigEndian.PutUint64(config.TraceID[:8], sc.TraceIDHigh)
+	binary.BigEndian.PutUint64(config.TraceID[8:], sc.TraceID)
+	binary.BigEndian.PutUint64(config.SpanID[:], sc.SpanID)
+	if sc.Sampled == nil || sc.sampled() {
+		config.TraceFlags = trace.FlagsSampled
+	}
+	config.TraceState, _ = trace.ParseTraceState(sc.TraceState)
+	return trace.NewSpanContext(config)
+}
+
+func fromOtelSpanContext(otelSc trace.SpanContext) SpanContext {
+	traceID, spanID := otelSc.TraceID(), otelSc.SpanID()
+	sampled := otelSc.IsSampled()
+	return SpanContext{
+		TraceIDHigh: binary.BigEndian.Uint64(traceID[:8]),
+		TraceID:     binary.BigEndian.Uint64(traceID[8:]),
+		SpanID:      binary.BigEndian.Uint64(spanID[:]),
+		Sampled:     &sampled,
+		TraceState:  otelSc.TraceState().String(),
+	}
+}
+
+// toCarrier returns carrier as a Carrier, not deleting headers unless it is one.
+func toCarrier(carrier propagation.TextMapCarrier) Carrier {
+	if c, ok := carrier.(Carrier); ok {
+		return c
+	}
+	return textMapCarrier{carrier}
+}
+
+type textMapCarrier struct {
+	propagation.TextMapCarrier
+}
+
+func (textMapCarrier) Del(key string) {}
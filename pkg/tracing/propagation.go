@@ -0,0 +1,462 @@
+package tracing
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"httpbin/pkg/options"
+)
+
+// Trace headers of the propagation formats.
+const (
+	headerTraceparent = "traceparent"
+	headerTracestate  = "tracestate"
+
+	headerB3             = "b3"
+	headerB3TraceID      = "x-b3-traceid"
+	headerB3SpanID       = "x-b3-spanid"
+	headerB3ParentSpanID = "x-b3-parentspanid"
+	headerB3Sampled      = "x-b3-sampled"
+	headerB3Flags        = "x-b3-flags"
+
+	headerJaeger = "uber-trace-id"
+
+	headerDatadogTraceID          = "x-datadog-trace-id"
+	headerDatadogParentID         = "x-datadog-parent-id"
+	headerDatadogSamplingPriority = "x-datadog-sampling-priority"
+	headerDatadogTags             = "x-datadog-tags"
+	// Datadog tag of the upper 64 bits of 128 bit trace ids.
+	datadogTraceIDHighTag = "_dd.p.tid"
+)
+
+// SpanContext is the trace context carried by the propagation formats.
+type SpanContext struct {
+	// TraceIDHigh is the upper 64 bits of a 128 bit trace id, 0 for 64 bit trace ids.
+	TraceIDHigh uint64
+	TraceID     uint64
+	SpanID      uint64
+	// ParentID of SpanID, only carried by B3 and Jaeger.
+	ParentID uint64
+	// Sampled is nil when the sampling is deferred to httpbin.
+	Sampled *bool
+	Debug   bool
+	// TraceState of W3C.
+	TraceState string
+}
+
+func (sc SpanContext) traceIDHex() string {
+	if sc.TraceIDHigh != 0 {
+		return fmt.Sprintf("%016x%016x", sc.TraceIDHigh, sc.TraceID)
+	}
+	return fmt.Sprintf("%016x", sc.TraceID)
+}
+
+func (sc SpanContext) sampled() bool {
+	return sc.Debug || (sc.Sampled != nil && *sc.Sampled)
+}
+
+// Carrier carries the trace headers of a request, the http headers or grpc metadata.
+type Carrier interface {
+	Get(key string) string
+	Set(key string, value string)
+	Del(key string)
+}
+
+// Propagator extracts and injects a trace propagation format.
+type Propagator interface {
+	Extract(carrier Carrier) (SpanContext, bool)
+	Inject(sc SpanContext, carrier Carrier)
+	Fields() []string
+}
+
+var propagators = map[string]Propagator{
+	options.PropagationW3C:     w3cPropagator{},
+	options.PropagationB3:      b3Propagator{},
+	options.PropagationB3Multi: b3MultiPropagator{},
+	options.PropagationJaeger:  jaegerPropagator{},
+	options.PropagationDatadog: datadogPropagator{},
+}
+
+// Propagation extracts the trace of the incoming requests with the first format of
+// --trace-extract found, and injects the trace into the next hops with the formats of
+// --trace-inject, so that httpbin joins traces started by any of them and translates
+// between the formats.
+type Propagation struct {
+	extract []Propagator
+	inject  []Propagator
+	fields  []string
+}
+
+// NewPropagation returns the propagation of option, injecting the native formats of the
+// tracer without --trace-inject.
+func NewPropagation(option *options.Option, native ...string) (*Propagation, error) {
+	inject := option.TraceInject
+	if len(inject) == 0 {
+		inject = native
+	}
+	p := &Propagation{}
+	var err error
+	if p.extract, err = lookupPropagators(option.TraceExtract); err != nil {
+		return nil, err
+	}
+	if p.inject, err = lookupPropagators(inject); err != nil {
+		return nil, err
+	}
+	for _, propagator := range append(p.extract, p.inject...) {
+		p.fields = append(p.fields, propagator.Fields()...)
+	}
+	return p, nil
+}
+
+func lookupPropagators(names []string) ([]Propagator, error) {
+	var result []Propagator
+	for _, name := range names {
+		propagator, ok := propagators[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return nil, fmt.Errorf("unknown trace propagation format %s", name)
+		}
+		result = append(result, propagator)
+	}
+	return result, nil
+}
+
+// Extract returns the trace context of the first format found in carrier.
+func (p *Propagation) Extract(carrier Carrier) (SpanContext, bool) {
+	for _, propagator := range p.extract {
+		if sc, ok := propagator.Extract(carrier); ok {
+			return sc, true
+		}
+	}
+	return SpanContext{}, false
+}
+
+// Inject replaces the trace headers of all the extracted and injected formats, passed on
+// from the incoming request, with sc in the injected formats.
+func (p *Propagation) Inject(sc SpanContext, carrier Carrier) {
+	if len(p.inject) == 0 {
+		return
+	}
+	for _, field := range p.fields {
+		carrier.Del(field)
+	}
+	for _, propagator := range p.inject {
+		propagator.Inject(sc, carrier)
+	}
+}
+
+// Injects reports whether p injects any format.
+func (p *Propagation) Injects() bool {
+	return len(p.inject) > 0
+}
+
+// w3cPropagator propagates W3C traceparent and tracestate,
+// e.g. traceparent: 00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01.
+type w3cPropagator struct{}
+
+func (w3cPropagator) Extract(carrier Carrier) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(carrier.Get(headerTraceparent)), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) ||
+		len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return SpanContext{}, false
+	}
+	sc := SpanContext{TraceState: carrier.Get(headerTracestate)}
+	var err error
+	if sc.TraceIDHigh, sc.TraceID, err = parseTraceID(parts[1]); err != nil || (sc.TraceIDHigh == 0 && sc.TraceID == 0) {
+		return SpanContext{}, false
+	}
+	if sc.SpanID, err = strconv.ParseUint(parts[2], 16, 64); err != nil || sc.SpanID == 0 {
+		return SpanContext{}, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sampled := flags&1 == 1
+	sc.Sampled = &sampled
+	return sc, true
+}
+
+func (w3cPropagator) Inject(sc SpanContext, carrier Carrier) {
+	flags := 0
+	if sc.sampled() {
+		flags = 1
+	}
+	carrier.Set(headerTraceparent, fmt.Sprintf("00-%016x%016x-%016x-%02x", sc.TraceIDHigh, sc.TraceID, sc.SpanID, flags))
+	if len(sc.TraceState) > 0 {
+		carrier.Set(headerTracestate, sc.TraceState)
+	}
+}
+
+func (w3cPropagator) Fields() []string {
+	return []string{headerTraceparent, headerTracestate}
+}
+
+// b3Propagator propagates the B3 single header, b3: {TraceId}-{SpanId}-{SamplingState}-{ParentSpanId}.
+type b3Propagator struct{}
+
+func (b3Propagator) Extract(carrier Carrier) (SpanContext, bool) {
+	parts := strings.Split(strings.TrimSpace(carrier.Get(headerB3)), "-")
+	if len(parts) < 2 || len(parts) > 4 {
+		return SpanContext{}, false
+	}
+	sc := SpanContext{}
+	var err error
+	if sc.TraceIDHigh, sc.TraceID, err = parseTraceID(parts[0]); err != nil {
+		return SpanContext{}, false
+	}
+	if sc.SpanID, err = strconv.ParseUint(parts[1], 16, 64); err != nil {
+		return SpanContext{}, false
+	}
+	if len(parts) > 2 {
+		switch parts[2] {
+		case "d":
+			sc.Debug = true
+		case "1", "0":
+			sampled := parts[2] == "1"
+			sc.Sampled = &sampled
+		default:
+			return SpanContext{}, false
+		}
+	}
+	if len(parts) > 3 {
+		if sc.ParentID, err = strconv.ParseUint(parts[3], 16, 64); err != nil {
+			return SpanContext{}, false
+		}
+	}
+	return sc, true
+}
+
+func (b3Propagator) Inject(sc SpanContext, carrier Carrier) {
+	value := fmt.Sprintf("%s-%016x", sc.traceIDHex(), sc.SpanID)
+	switch {
+	case sc.Debug:
+		value += "-d"
+	case sc.Sampled != nil:
+		value += "-" + b3Sampled(*sc.Sampled)
+	}
+	if (sc.Debug || sc.Sampled != nil) && sc.ParentID != 0 {
+		value += fmt.Sprintf("-%016x", sc.ParentID)
+	}
+	carrier.Set(headerB3, value)
+}
+
+func (b3Propagator) Fields() []string {
+	return []string{headerB3}
+}
+
+// b3MultiPropagator propagates the B3 headers X-B3-TraceId, X-B3-SpanId, X-B3-ParentSpanId,
+// X-B3-Sampled and X-B3-Flags.
+type b3MultiPropagator struct{}
+
+func (b3MultiPropagator) Extract(carrier Carrier) (SpanContext, bool) {
+	sc := SpanContext{}
+	var err error
+	if sc.TraceIDHigh, sc.TraceID, err = parseTraceID(carrier.Get(headerB3TraceID)); err != nil {
+		return SpanContext{}, false
+	}
+	if sc.SpanID, err = strconv.ParseUint(carrier.Get(headerB3SpanID), 16, 64); err != nil {
+		return SpanContext{}, false
+	}
+	if parentID := carrier.Get(headerB3ParentSpanID); len(parentID) > 0 {
+		if sc.ParentID, err = strconv.ParseUint(parentID, 16, 64); err != nil {
+			return SpanContext{}, false
+		}
+	}
+	switch strings.ToLower(carrier.Get(headerB3Sampled)) {
+	case "1", "true":
+		sampled := true
+		sc.Sampled = &sampled
+	case "0", "false":
+		sampled := false
+		sc.Sampled = &sampled
+	}
+	sc.Debug = carrier.Get(headerB3Flags) == "1"
+	return sc, true
+}
+
+func (b3MultiPropagator) Inject(sc SpanContext, carrier Carrier) {
+	carrier.Set(headerB3TraceID, sc.traceIDHex())
+	carrier.Set(headerB3SpanID, fmt.Sprintf("%016x", sc.SpanID))
+	if sc.ParentID != 0 {
+		carrier.Set(headerB3ParentSpanID, fmt.Sprintf("%016x", sc.ParentID))
+	}
+	if sc.Debug {
+		carrier.Set(headerB3Flags, "1")
+	} else if sc.Sampled != nil {
+		carrier.Set(headerB3Sampled, b3Sampled(*sc.Sampled))
+	}
+}
+
+func (b3MultiPropagator) Fields() []string {
+	return []string{headerB3TraceID, headerB3SpanID, headerB3ParentSpanID, headerB3Sampled, headerB3Flags}
+}
+
+// jaegerPropagator propagates uber-trace-id: {trace-id}:{span-id}:{parent-span-id}:{flags},
+// flags 1 for sampled and 2 for debug.
+type jaegerPropagator struct{}
+
+func (jaegerPropagator) Extract(carrier Carrier) (SpanContext, bool) {
+	value, err := url.QueryUnescape(carrier.Get(headerJaeger))
+	if err != nil {
+		return SpanContext{}, false
+	}
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 4 {
+		return SpanContext{}, false
+	}
+	sc := SpanContext{}
+	if sc.TraceIDHigh, sc.TraceID, err = parseTraceID(parts[0]); err != nil || (sc.TraceIDHigh == 0 && sc.TraceID == 0) {
+		return SpanContext{}, false
+	}
+	if sc.SpanID, err = strconv.ParseUint(parts[1], 16, 64); err != nil || sc.SpanID == 0 {
+		return SpanContext{}, false
+	}
+	if sc.ParentID, err = strconv.ParseUint(parts[2], 16, 64); err != nil {
+		return SpanContext{}, false
+	}
+	flags, err := strconv.ParseUint(parts[3], 16, 8)
+	if err != nil {
+		return SpanContext{}, false
+	}
+	sampled := flags&1 == 1
+	sc.Sampled = &sampled
+	sc.Debug = flags&2 == 2
+	return sc, true
+}
+
+func (jaegerPropagator) Inject(sc SpanContext, carrier Carrier) {
+	flags := 0
+	if sc.sampled() {
+		flags |= 1
+	}
+	if sc.Debug {
+		flags |= 2
+	}
+	carrier.Set(headerJaeger, fmt.Sprintf("%s:%016x:%x:%x", sc.traceIDHex(), sc.SpanID, sc.ParentID, flags))
+}
+
+func (jaegerPropagator) Fields() []string {
+	return []string{headerJaeger}
+}
+
+// datadogPropagator propagates the decimal x-datadog-trace-id and x-datadog-parent-id with
+// x-datadog-sampling-priority, the upper 64 bits of 128 bit trace ids in x-datadog-tags.
+type datadogPropagator struct{}
+
+func (datadogPropagator) Extract(carrier Carrier) (SpanContext, bool) {
+	sc := SpanContext{}
+	var err error
+	if sc.TraceID, err = strconv.ParseUint(carrier.Get(headerDatadogTraceID), 10, 64); err != nil || sc.TraceID == 0 {
+		return SpanContext{}, false
+	}
+	if sc.SpanID, err = strconv.ParseUint(carrier.Get(headerDatadogParentID), 10, 64); err != nil {
+		return SpanContext{}, false
+	}
+	if priority, err := strconv.Atoi(carrier.Get(headerDatadogSamplingPriority)); err == nil {
+		sampled := priority > 0
+		sc.Sampled = &sampled
+		// USER_KEEP
+		sc.Debug = priority > 1
+	}
+	for _, tag := range strings.Split(carrier.Get(headerDatadogTags), ",") {
+		if key, value, ok := strings.Cut(tag, "="); ok && key == datadogTraceIDHighTag {
+			sc.TraceIDHigh, _ = strconv.ParseUint(value, 16, 64)
+		}
+	}
+	return sc, true
+}
+
+func (datadogPropagator) Inject(sc SpanContext, carrier Carrier) {
+	carrier.Set(headerDatadogTraceID, strconv.FormatUint(sc.TraceID, 10))
+	carrier.Set(headerDatadogParentID, strconv.FormatUint(sc.SpanID, 10))
+	switch {
+	case sc.Debug:
+		carrier.Set(headerDatadogSamplingPriority, "2")
+	case sc.Sampled != nil:
+		carrier.Set(headerDatadogSamplingPriority, b3Sampled(*sc.Sampled))
+	}
+	if sc.TraceIDHigh != 0 {
+		carrier.Set(headerDatadogTags, fmt.Sprintf("%s=%016x", datadogTraceIDHighTag, sc.TraceIDHigh))
+	}
+}
+
+func (datadogPropagator) Fields() []string {
+	return []string{headerDatadogTraceID, headerDatadogParentID, headerDatadogSamplingPriority, headerDatadogTags}
+}
+
+// parseTraceID parses a hex trace id of up to 128 bits.
+func parseTraceID(s string) (high uint64, low uint64, err error) {
+	if len(s) == 0 || len(s) > 32 {
+		return 0, 0, fmt.Errorf("invalid trace id %q", s)
+	}
+	if len(s) > 16 {
+		if high, err = strconv.ParseUint(s[:len(s)-16], 16, 64); err != nil {
+			return 0, 0, err
+		}
+		s = s[len(s)-16:]
+	}
+	low, err = strconv.ParseUint(s, 16, 64)
+	return high, low, err
+}
+
+func b3Sampled(sampled bool) string {
+	if sampled {
+		return "1"
+	}
+	return "0"
+}
+
+// headerCarrier carries the trace headers of http requests, replacing the lower case
+// headers passed on from the incoming request.
+type headerCarrier http.Header
+
+func (c headerCarrier) Get(key string) string {
+	return http.Header(c).Get(key)
+}
+
+func (c headerCarrier) Set(key string, value string) {
+	setHeader(http.Header(c), key, value)
+}
+
+func (c headerCarrier) Del(key string) {
+	delete(c, strings.ToLower(key))
+	http.Header(c).Del(key)
+}
+
+func (c headerCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// metadataCarrier carries the trace headers of grpc calls.
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	if values := metadata.MD(c).Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+func (c metadataCarrier) Set(key string, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Del(key string) {
+	metadata.MD(c).Delete(key)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for key := range c {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,187 @@
+package tracing
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"httpbin/pkg/options"
+)
+
+func boolPtr(b bool) *bool {
+	return &b
+}
+
+func TestPropagatorRoundTrip(t *testing.T) {
+	contexts := map[string]SpanContext{
+		"sampled":        {TraceID: 0x1234, SpanID: 0x5678, ParentID: 0x9abc, Sampled: boolPtr(true)},
+		"not sampled":    {TraceID: 0x1234, SpanID: 0x5678, ParentID: 0x9abc, Sampled: boolPtr(false)},
+		"debug":          {TraceID: 0x1234, SpanID: 0x5678, ParentID: 0x9abc, Sampled: boolPtr(true), Debug: true},
+		"128 bit":        {TraceIDHigh: 0xabcd, TraceID: 0x1234, SpanID: 0x5678, Sampled: boolPtr(true)},
+		"w3c tracestate": {TraceID: 0x1234, SpanID: 0x5678, Sampled: boolPtr(true), TraceState: "vendor=value"},
+	}
+	tests := []struct {
+		format string
+		// Fields not carried by the format, cleared before comparing.
+		clear func(sc *SpanContext)
+	}{
+		{options.PropagationW3C, func(sc *SpanContext) { sc.ParentID, sc.Debug = 0, false }},
+		{options.PropagationB3, func(sc *SpanContext) {
+			sc.TraceState = ""
+			if sc.Debug {
+				// The debug flag implies sampling and is sent without the sampled flag.
+				sc.Sampled = nil
+			}
+		}},
+		{options.PropagationB3Multi, func(sc *SpanContext) {
+			sc.TraceState = ""
+			if sc.Debug {
+				sc.Sampled = nil
+			}
+		}},
+		{options.PropagationJaeger, func(sc *SpanContext) { sc.TraceState = "" }},
+		{options.PropagationDatadog, func(sc *SpanContext) { sc.ParentID, sc.TraceState = 0, "" }},
+	}
+	for _, tt := range tests {
+		for name, sc := range contexts {
+			propagator := propagators[tt.format]
+			carrier := headerCarrier(http.Header{})
+			propagator.Inject(sc, carrier)
+			got, ok := propagator.Extract(carrier)
+			if !ok {
+				t.Errorf("%s %s: extract of %v failed", tt.format, name, http.Header(carrier))
+				continue
+			}
+			want := sc
+			tt.clear(&want)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s %s: extract = %+v, want %+v", tt.format, name, got, want)
+			}
+		}
+	}
+}
+
+func TestPropagatorExtract(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  string
+		headers map[string]string
+		want    *SpanContext
+	}{
+		{
+			name:    "w3c",
+			format:  options.PropagationW3C,
+			headers: map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"},
+			want:    &SpanContext{TraceIDHigh: 0x0af7651916cd43dd, TraceID: 0x8448eb211c80319c, SpanID: 0xb7ad6b7169203331, Sampled: boolPtr(true)},
+		},
+		{
+			name:    "w3c future version with more fields",
+			format:  options.PropagationW3C,
+			headers: map[string]string{"traceparent": "01-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00-extra"},
+			want:    &SpanContext{TraceIDHigh: 0x0af7651916cd43dd, TraceID: 0x8448eb211c80319c, SpanID: 0xb7ad6b7169203331, Sampled: boolPtr(false)},
+		},
+		{name: "w3c version 00 with more fields", format: options.PropagationW3C, headers: map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01-extra"}},
+		{name: "w3c version ff", format: options.PropagationW3C, headers: map[string]string{"traceparent": "ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}},
+		{name: "w3c zero trace id", format: options.PropagationW3C, headers: map[string]string{"traceparent": "00-00000000000000000000000000000000-b7ad6b7169203331-01"}},
+		{name: "w3c zero span id", format: options.PropagationW3C, headers: map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-0000000000000000-01"}},
+		{name: "w3c short trace id", format: options.PropagationW3C, headers: map[string]string{"traceparent": "00-0af7651916cd43dd-b7ad6b7169203331-01"}},
+		{
+			name:    "b3 without sampling state",
+			format:  options.PropagationB3,
+			headers: map[string]string{"b3": "80f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1"},
+			want:    &SpanContext{TraceIDHigh: 0x80f198ee56343ba8, TraceID: 0x64fe8b2a57d3eff7, SpanID: 0xe457b5a2e4d86bd1},
+		},
+		{
+			name:    "b3 debug with parent",
+			format:  options.PropagationB3,
+			headers: map[string]string{"b3": "64fe8b2a57d3eff7-e457b5a2e4d86bd1-d-05e3ac9a4f6e3b90"},
+			want:    &SpanContext{TraceID: 0x64fe8b2a57d3eff7, SpanID: 0xe457b5a2e4d86bd1, ParentID: 0x05e3ac9a4f6e3b90, Debug: true},
+		},
+		// A sampling state alone is no trace, see extractB3SamplingState.
+		{name: "b3 deny only", format: options.PropagationB3, headers: map[string]string{"b3": "0"}},
+		{name: "b3 invalid sampling state", format: options.PropagationB3, headers: map[string]string{"b3": "64fe8b2a57d3eff7-e457b5a2e4d86bd1-x"}},
+		{name: "b3 too many fields", format: options.PropagationB3, headers: map[string]string{"b3": "64fe8b2a57d3eff7-e457b5a2e4d86bd1-1-05e3ac9a4f6e3b90-1"}},
+		{name: "b3 trace id longer than 128 bits", format: options.PropagationB3, headers: map[string]string{"b3": "0080f198ee56343ba864fe8b2a57d3eff7-e457b5a2e4d86bd1"}},
+		{
+			name:    "b3 multi",
+			format:  options.PropagationB3Multi,
+			headers: map[string]string{"X-B3-TraceId": "64fe8b2a57d3eff7", "X-B3-SpanId": "e457b5a2e4d86bd1", "X-B3-Sampled": "true"},
+			want:    &SpanContext{TraceID: 0x64fe8b2a57d3eff7, SpanID: 0xe457b5a2e4d86bd1, Sampled: boolPtr(true)},
+		},
+		{name: "b3 multi sampled without ids", format: options.PropagationB3Multi, headers: map[string]string{"X-B3-Sampled": "1"}},
+		{name: "b3 multi invalid parent", format: options.PropagationB3Multi, headers: map[string]string{"X-B3-TraceId": "64fe8b2a57d3eff7", "X-B3-SpanId": "e457b5a2e4d86bd1", "X-B3-ParentSpanId": "xyz"}},
+		{
+			name:    "jaeger url encoded",
+			format:  options.PropagationJaeger,
+			headers: map[string]string{"uber-trace-id": "64fe8b2a57d3eff7%3Ae457b5a2e4d86bd1%3A0%3A3"},
+			want:    &SpanContext{TraceID: 0x64fe8b2a57d3eff7, SpanID: 0xe457b5a2e4d86bd1, Sampled: boolPtr(true), Debug: true},
+		},
+		{name: "jaeger missing flags", format: options.PropagationJaeger, headers: map[string]string{"uber-trace-id": "64fe8b2a57d3eff7:e457b5a2e4d86bd1:0"}},
+		{name: "jaeger zero span id", format: options.PropagationJaeger, headers: map[string]string{"uber-trace-id": "64fe8b2a57d3eff7:0:0:1"}},
+		{
+			name:   "datadog user keep is debug",
+			format: options.PropagationDatadog,
+			headers: map[string]string{
+				"x-datadog-trace-id":          "7277407061855694839",
+				"x-datadog-parent-id":         "16453819474850114513",
+				"x-datadog-sampling-priority": "2",
+				"x-datadog-tags":              "_dd.p.dm=-4,_dd.p.tid=80f198ee56343ba8",
+			},
+			want: &SpanContext{TraceIDHigh: 0x80f198ee56343ba8, TraceID: 7277407061855694839, SpanID: 16453819474850114513, Sampled: boolPtr(true), Debug: true},
+		},
+		{
+			name:    "datadog user reject",
+			format:  options.PropagationDatadog,
+			headers: map[string]string{"x-datadog-trace-id": "1", "x-datadog-parent-id": "2", "x-datadog-sampling-priority": "-1"},
+			want:    &SpanContext{TraceID: 1, SpanID: 2, Sampled: boolPtr(false)},
+		},
+		{name: "datadog hex trace id", format: options.PropagationDatadog, headers: map[string]string{"x-datadog-trace-id": "64fe8b2a57d3eff7", "x-datadog-parent-id": "2"}},
+		{name: "datadog zero trace id", format: options.PropagationDatadog, headers: map[string]string{"x-datadog-trace-id": "0", "x-datadog-parent-id": "2"}},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		for k, v := range tt.headers {
+			header.Set(k, v)
+		}
+		got, ok := propagators[tt.format].Extract(headerCarrier(header))
+		if tt.want == nil {
+			if ok {
+				t.Errorf("%s: extract = %+v, want none", tt.name, got)
+			}
+			continue
+		}
+		if !ok || !reflect.DeepEqual(got, *tt.want) {
+			t.Errorf("%s: extract = %+v, %v, want %+v", tt.name, got, ok, *tt.want)
+		}
+	}
+}
+
+func TestPropagationTranslate(t *testing.T) {
+	option := &options.Option{
+		TraceExtract: []string{options.PropagationB3, options.PropagationW3C},
+		TraceInject:  []string{options.PropagationW3C},
+	}
+	p, err := NewPropagation(option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := http.Header{}
+	header.Set("b3", "64fe8b2a57d3eff7-e457b5a2e4d86bd1-1")
+	header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00")
+	sc, ok := p.Extract(headerCarrier(header))
+	if !ok || sc.TraceID != 0x64fe8b2a57d3eff7 {
+		t.Fatalf("extract = %+v, %v, want the b3 trace extracted first", sc, ok)
+	}
+	sc.SpanID = 0x1
+	p.Inject(sc, headerCarrier(header))
+	if got := header.Get("b3"); len(got) > 0 {
+		t.Errorf("b3 = %s, want removed", got)
+	}
+	if got, want := header.Get("traceparent"), "00-000000000000000064fe8b2a57d3eff7-0000000000000001-01"; got != want {
+		t.Errorf("traceparent = %s, want %s", got, want)
+	}
+
+	if _, err := NewPropagation(&options.Option{TraceExtract: []string{"unknown"}}); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
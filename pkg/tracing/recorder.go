@@ -0,0 +1,265 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SkyAPM/go2sky"
+	zipkinmodel "github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"httpbin/pkg/model"
+	"httpbin/pkg/options"
+	agentv3 "skywalking.apache.org/repo/goapi/collect/language/agent/v3"
+)
+
+const (
+	spanKindServer   = "server"
+	spanKindClient   = "client"
+	spanKindInternal = "internal"
+
+	traceExportStdout = "stdout"
+)
+
+// Recorder keeps the last --trace-buffer-size finished spans of the tracer and writes them
+// as JSON lines to --trace-export, to verify the spans without a tracing backend.
+type Recorder struct {
+	mu     sync.Mutex
+	spans  []recordedSpan
+	next   int
+	export *json.Encoder
+	closer io.Closer
+}
+
+type recordedSpan struct {
+	span     *model.Span
+	duration time.Duration
+}
+
+// SpanFilter selects the recorded spans, zero values match all spans.
+type SpanFilter struct {
+	TraceID     string
+	Service     string
+	MinDuration time.Duration
+	MaxDuration time.Duration
+	Limit       int
+}
+
+// NewRecorder returns the recorder of option, nil without --trace-buffer-size and --trace-export.
+func NewRecorder(option *options.Option) (*Recorder, error) {
+	if option.TraceBufferSize <= 0 && len(option.TraceExport) == 0 {
+		return nil, nil
+	}
+	r := &Recorder{}
+	if option.TraceBufferSize > 0 {
+		r.spans = make([]recordedSpan, 0, option.TraceBufferSize)
+	}
+	switch option.TraceExport {
+	case "":
+	case traceExportStdout:
+		r.export = json.NewEncoder(os.Stdout)
+	default:
+		file, err := os.OpenFile(option.TraceExport, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, err
+		}
+		r.export = json.NewEncoder(file)
+		r.closer = file
+	}
+	return r, nil
+}
+
+// Record keeps span, replacing the oldest span when the buffer is full.
+func (r *Recorder) Record(span *model.Span, duration time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.export != nil {
+		_ = r.export.Encode(span)
+	}
+	switch {
+	case cap(r.spans) == 0:
+	case len(r.spans) < cap(r.spans):
+		r.spans = append(r.spans, recordedSpan{span: span, duration: duration})
+	default:
+		r.spans[r.next] = recordedSpan{span: span, duration: duration}
+		r.next = (r.next + 1) % len(r.spans)
+	}
+}
+
+// Spans returns the recorded spans matching filter, oldest first. With a limit only the
+// latest spans are returned.
+func (r *Recorder) Spans(filter SpanFilter) []*model.Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	traceID := strings.TrimLeft(strings.ToLower(filter.TraceID), "0")
+	spans := make([]*model.Span, 0)
+	for i := range r.spans {
+		recorded := r.spans[(r.next+i)%len(r.spans)]
+		switch {
+		case len(traceID) > 0 && strings.TrimLeft(recorded.span.TraceID, "0") != traceID:
+		case len(filter.Service) > 0 && recorded.span.Service != filter.Service:
+		case filter.MinDuration > 0 && recorded.duration < filter.MinDuration:
+		case filter.MaxDuration > 0 && recorded.duration > filter.MaxDuration:
+		default:
+			spans = append(spans, recorded.span)
+		}
+	}
+	if filter.Limit > 0 && len(spans) > filter.Limit {
+		spans = spans[len(spans)-filter.Limit:]
+	}
+	return spans
+}
+
+func (r *Recorder) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
+
+func newRecordedSpan(start time.Time, end time.Time) (*model.Span, time.Duration) {
+	duration := end.Sub(start)
+	return &model.Span{Start: start, Duration: duration.String()}, duration
+}
+
+// zipkinRecorder records the spans reported to Zipkin, then passes them on to next if set.
+type zipkinRecorder struct {
+	next     reporter.Reporter
+	recorder *Recorder
+}
+
+func (r zipkinRecorder) Send(s zipkinmodel.SpanModel) {
+	span, duration := newRecordedSpan(s.Timestamp, s.Timestamp.Add(s.Duration))
+	span.TraceID = s.TraceID.String()
+	span.SpanID = s.ID.String()
+	if s.ParentID != nil {
+		span.ParentID = s.ParentID.String()
+	}
+	if s.LocalEndpoint != nil {
+		span.Service = s.LocalEndpoint.ServiceName
+	}
+	span.Name = s.Name
+	span.Kind = strings.ToLower(string(s.Kind))
+	_, span.Error = s.Tags["error"]
+	span.Tags = s.Tags
+	r.recorder.Record(span, duration)
+	if r.next != nil {
+		r.next.Send(s)
+	}
+}
+
+func (r zipkinRecorder) Close() error {
+	if r.next != nil {
+		return r.next.Close()
+	}
+	return nil
+}
+
+// skywalkingRecorder records the segments reported to SkyWalking, then passes them on to
+// next if set. The span ids are {segment id}-{span id}.
+type skywalkingRecorder struct {
+	next     go2sky.Reporter
+	recorder *Recorder
+	service  string
+}
+
+func (r skywalkingRecorder) Boot(service string, serviceInstance string, cdsWatchers []go2sky.AgentConfigChangeWatcher) {
+	if r.next != nil {
+		r.next.Boot(service, serviceInstance, cdsWatchers)
+	}
+}
+
+func (r skywalkingRecorder) Send(spans []go2sky.ReportedSpan) {
+	for _, s := range spans {
+		sc := s.Context()
+		span, duration := newRecordedSpan(time.UnixMilli(s.StartTime()), time.UnixMilli(s.EndTime()))
+		span.TraceID = sc.TraceID
+		span.SpanID = fmt.Sprintf("%s-%d", sc.SegmentID, sc.SpanID)
+		if sc.ParentSpanID >= 0 {
+			span.ParentID = fmt.Sprintf("%s-%d", sc.SegmentID, sc.ParentSpanID)
+		} else if refs := s.Refs(); len(refs) > 0 {
+			span.ParentID = fmt.Sprintf("%s-%d", refs[0].ParentSegmentID, refs[0].ParentSpanID)
+		}
+		span.Service = r.service
+		span.Name = s.OperationName()
+		switch s.SpanType() {
+		case agentv3.SpanType_Entry:
+			span.Kind = spanKindServer
+		case agentv3.SpanType_Exit:
+			span.Kind = spanKindClient
+		default:
+			span.Kind = spanKindInternal
+		}
+		span.Error = s.IsError()
+		if tags := s.Tags(); len(tags) > 0 {
+			span.Tags = make(map[string]string, len(tags))
+			for _, tag := range tags {
+				span.Tags[tag.Key] = tag.Value
+			}
+		}
+		r.recorder.Record(span, duration)
+	}
+	if r.next != nil {
+		r.next.Send(spans)
+	}
+}
+
+func (r skywalkingRecorder) Close() {
+	if r.next != nil {
+		r.next.Close()
+	}
+}
+
+// otelRecorder is the sdktrace.SpanExporter recording the OpenTelemetry spans.
+type otelRecorder struct {
+	recorder *Recorder
+}
+
+func (r otelRecorder) ExportSpans(ctx context.Context, spans []sdktrace.ReadOnlySpan) error {
+	for _, s := range spans {
+		span, duration := newRecordedSpan(s.StartTime(), s.EndTime())
+		span.TraceID = s.SpanContext().TraceID().String()
+		span.SpanID = s.SpanContext().SpanID().String()
+		if parent := s.Parent(); parent.IsValid() {
+			span.ParentID = parent.SpanID().String()
+		}
+		for _, attr := range s.Resource().Attributes() {
+			if attr.Key == semconv.ServiceNameKey {
+				span.Service = attr.Value.AsString()
+			}
+		}
+		span.Name = s.Name()
+		switch s.SpanKind() {
+		case trace.SpanKindServer:
+			span.Kind = spanKindServer
+		case trace.SpanKindClient:
+			span.Kind = spanKindClient
+		default:
+			span.Kind = s.SpanKind().String()
+		}
+		span.Error = s.Status().Code == codes.Error
+		if attrs := s.Attributes(); len(attrs) > 0 {
+			span.Tags = make(map[string]string, len(attrs))
+			for _, attr := range attrs {
+				span.Tags[string(attr.Key)] = attr.Value.Emit()
+			}
+		}
+		r.recorder.Record(span, duration)
+	}
+	return nil
+}
+
+func (r otelRecorder) Shutdown(ctx context.Context) error {
+	return nil
+}
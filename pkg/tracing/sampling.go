@@ -0,0 +1,129 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+
+	"httpbin/pkg/model"
+	"httpbin/pkg/options"
+)
+
+// HeaderForceTrace forces the sampling of a request with true and drops it with false,
+// overriding the upstream decision and the sample rates.
+const HeaderForceTrace = "x-httpbin-force-trace"
+
+// Reasons of the sampling decisions.
+const (
+	SamplingForce  = "force"
+	SamplingDebug  = "debug"
+	SamplingParent = "parent"
+	SamplingRoute  = "route"
+	SamplingRate   = "rate"
+)
+
+// Sampler decides the sampling of the entry spans of the tracers, in order of precedence by
+// HeaderForceTrace, the B3 debug flag, the sampled flag of the upstream trace,
+// --route-sample-rate and --sample-rate.
+type Sampler struct {
+	rate     float64
+	routes   map[string]float64
+	prefixes []routeRate
+}
+
+type routeRate struct {
+	prefix string
+	rate   float64
+}
+
+func NewSampler(option *options.Option) (*Sampler, error) {
+	s := &Sampler{rate: option.SamplingRate, routes: make(map[string]float64)}
+	for route, value := range option.RouteSamplingRates {
+		rate, err := strconv.ParseFloat(value, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid route sample rate %s=%s", route, value)
+		}
+		if strings.HasSuffix(route, "*") {
+			s.prefixes = append(s.prefixes, routeRate{prefix: strings.TrimSuffix(route, "*"), rate: rate})
+		} else {
+			s.routes[route] = rate
+		}
+	}
+	// The longest prefix matches first.
+	sort.Slice(s.prefixes, func(i, j int) bool {
+		return len(s.prefixes[i].prefix) > len(s.prefixes[j].prefix)
+	})
+	return s, nil
+}
+
+// Sample decides the sampling of a request to route, the gin path or grpc method. parent is
+// the upstream trace extracted from carrier, if any.
+func (s *Sampler) Sample(route string, carrier Carrier, parent *SpanContext) *model.Sampling {
+	if force, err := strconv.ParseBool(carrier.Get(HeaderForceTrace)); err == nil {
+		return &model.Sampling{Sampled: force, Reason: SamplingForce}
+	}
+	if parent == nil {
+		if sc, ok := extractB3SamplingState(carrier); ok {
+			parent = &sc
+		}
+	}
+	if parent != nil && parent.Debug {
+		return &model.Sampling{Sampled: true, Reason: SamplingDebug}
+	}
+	if parent != nil && parent.Sampled != nil {
+		return &model.Sampling{Sampled: *parent.Sampled, Reason: SamplingParent}
+	}
+	rate, reason := s.rateOf(route)
+	return &model.Sampling{Sampled: rand.Float64() < rate, Reason: reason, Rate: &rate}
+}
+
+func (s *Sampler) rateOf(route string) (float64, string) {
+	if rate, ok := s.routes[route]; ok {
+		return rate, SamplingRoute
+	}
+	for _, r := range s.prefixes {
+		if strings.HasPrefix(route, r.prefix) {
+			return r.rate, SamplingRoute
+		}
+	}
+	return s.rate, SamplingRate
+}
+
+// extractB3SamplingState extracts the B3 headers carrying only the sampling state without a
+// trace, e.g. X-B3-Sampled: 1, X-B3-Flags: 1 or b3: d.
+func extractB3SamplingState(carrier Carrier) (SpanContext, bool) {
+	sc := SpanContext{}
+	switch strings.ToLower(carrier.Get(headerB3Sampled)) {
+	case "1", "true":
+		sampled := true
+		sc.Sampled = &sampled
+	case "0", "false":
+		sampled := false
+		sc.Sampled = &sampled
+	}
+	sc.Debug = carrier.Get(headerB3Flags) == "1"
+	switch b3 := strings.TrimSpace(carrier.Get(headerB3)); b3 {
+	case "d":
+		sc.Debug = true
+	case "1", "0":
+		sampled := b3 == "1"
+		sc.Sampled = &sampled
+	}
+	return sc, sc.Debug || sc.Sampled != nil
+}
+
+// samplingKey is the context key of the sampling decision of the request.
+type samplingKey struct{}
+
+func contextWithSampling(ctx context.Context, sampling *model.Sampling) context.Context {
+	return context.WithValue(ctx, samplingKey{}, sampling)
+}
+
+// SamplingFromContext returns the sampling decision of the request, nil if it is not traced.
+func SamplingFromContext(ctx context.Context) *model.Sampling {
+	sampling, _ := ctx.Value(samplingKey{}).(*model.Sampling)
+	return sampling
+}
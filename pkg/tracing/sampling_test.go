@@ -0,0 +1,167 @@
+package tracing
+
+import (
+	"net/http"
+	"testing"
+
+	"httpbin/pkg/options"
+)
+
+func TestSample(t *testing.T) {
+	option := &options.Option{
+		SamplingRate: 1,
+		RouteSamplingRates: map[string]string{
+			"/httpbin.Echo/*":            "0",
+			"/httpbin.Echo/service*":     "1",
+			"/httpbin.Echo/serviceGraph": "0",
+			"/health*":                   "0",
+		},
+	}
+	sampler, err := NewSampler(option)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		route   string
+		headers map[string]string
+		// Parent extracted by the propagation.
+		parent      *SpanContext
+		wantSampled bool
+		wantReason  string
+	}{
+		{name: "rate", route: "/service", wantSampled: true, wantReason: SamplingRate},
+		{name: "exact route", route: "/httpbin.Echo/serviceGraph", wantSampled: false, wantReason: SamplingRoute},
+		{name: "longest prefix", route: "/httpbin.Echo/service", wantSampled: true, wantReason: SamplingRoute},
+		{name: "shorter prefix", route: "/httpbin.Echo/unary", wantSampled: false, wantReason: SamplingRoute},
+		{name: "prefix", route: "/healthz", wantSampled: false, wantReason: SamplingRoute},
+		{
+			name:        "force overrides the parent",
+			route:       "/service",
+			headers:     map[string]string{HeaderForceTrace: "false"},
+			parent:      &SpanContext{TraceID: 1, SpanID: 2, Debug: true},
+			wantSampled: false,
+			wantReason:  SamplingForce,
+		},
+		{
+			name:        "invalid force is ignored",
+			route:       "/healthz",
+			headers:     map[string]string{HeaderForceTrace: "yes"},
+			wantSampled: false,
+			wantReason:  SamplingRoute,
+		},
+		{
+			name:        "parent not sampled",
+			route:       "/service",
+			parent:      &SpanContext{TraceID: 1, SpanID: 2, Sampled: boolPtr(false)},
+			wantSampled: false,
+			wantReason:  SamplingParent,
+		},
+		{
+			name:        "parent without sampling state",
+			route:       "/healthz",
+			parent:      &SpanContext{TraceID: 1, SpanID: 2},
+			wantSampled: false,
+			wantReason:  SamplingRoute,
+		},
+		{
+			name:        "b3 deny only",
+			route:       "/service",
+			headers:     map[string]string{"b3": "0"},
+			wantSampled: false,
+			wantReason:  SamplingParent,
+		},
+		{
+			name:        "b3 debug only",
+			route:       "/healthz",
+			headers:     map[string]string{"b3": "d"},
+			wantSampled: true,
+			wantReason:  SamplingDebug,
+		},
+		{
+			name:        "x-b3-sampled without ids",
+			route:       "/healthz",
+			headers:     map[string]string{"X-B3-Sampled": "1"},
+			wantSampled: true,
+			wantReason:  SamplingParent,
+		},
+		{
+			name:        "x-b3-flags without ids",
+			route:       "/healthz",
+			headers:     map[string]string{"X-B3-Flags": "1"},
+			wantSampled: true,
+			wantReason:  SamplingDebug,
+		},
+		{
+			name:        "datadog user keep",
+			route:       "/healthz",
+			headers:     map[string]string{"x-datadog-trace-id": "1", "x-datadog-parent-id": "2", "x-datadog-sampling-priority": "2"},
+			wantSampled: true,
+			wantReason:  SamplingDebug,
+		},
+		{
+			name:        "datadog auto reject",
+			route:       "/service",
+			headers:     map[string]string{"x-datadog-trace-id": "1", "x-datadog-parent-id": "2", "x-datadog-sampling-priority": "0"},
+			wantSampled: false,
+			wantReason:  SamplingParent,
+		},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		for k, v := range tt.headers {
+			header.Set(k, v)
+		}
+		carrier := headerCarrier(header)
+		parent := tt.parent
+		if sc, ok := propagators[options.PropagationDatadog].Extract(carrier); ok && parent == nil {
+			parent = &sc
+		}
+		got := sampler.Sample(tt.route, carrier, parent)
+		if got.Sampled != tt.wantSampled || got.Reason != tt.wantReason {
+			t.Errorf("%s: Sample = %v %s, want %v %s", tt.name, got.Sampled, got.Reason, tt.wantSampled, tt.wantReason)
+		}
+		if wantRate := tt.wantReason == SamplingRoute || tt.wantReason == SamplingRate; (got.Rate != nil) != wantRate {
+			t.Errorf("%s: rate = %v, want rate %v", tt.name, got.Rate, wantRate)
+		}
+	}
+}
+
+func TestNewSamplerInvalidRate(t *testing.T) {
+	for _, rate := range []string{"-0.1", "1.1", "half"} {
+		option := &options.Option{RouteSamplingRates: map[string]string{"/service": rate}}
+		if _, err := NewSampler(option); err == nil {
+			t.Errorf("route sample rate %s accepted", rate)
+		}
+	}
+}
+
+func TestExtractB3SamplingState(t *testing.T) {
+	tests := []struct {
+		headers     map[string]string
+		want        bool
+		wantSampled *bool
+		wantDebug   bool
+	}{
+		{headers: map[string]string{}},
+		{headers: map[string]string{"b3": "0"}, want: true, wantSampled: boolPtr(false)},
+		{headers: map[string]string{"b3": "1"}, want: true, wantSampled: boolPtr(true)},
+		{headers: map[string]string{"b3": "d"}, want: true, wantDebug: true},
+		{headers: map[string]string{"b3": "x"}},
+		{headers: map[string]string{"X-B3-Sampled": "false"}, want: true, wantSampled: boolPtr(false)},
+		{headers: map[string]string{"X-B3-Sampled": "maybe"}},
+		{headers: map[string]string{"X-B3-Flags": "1", "X-B3-Sampled": "0"}, want: true, wantSampled: boolPtr(false), wantDebug: true},
+		{headers: map[string]string{"X-B3-Flags": "0"}},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		for k, v := range tt.headers {
+			header.Set(k, v)
+		}
+		sc, ok := extractB3SamplingState(headerCarrier(header))
+		if ok != tt.want || sc.Debug != tt.wantDebug || (sc.Sampled == nil) != (tt.wantSampled == nil) ||
+			(sc.Sampled != nil && *sc.Sampled != *tt.wantSampled) {
+			t.Errorf("extractB3SamplingState(%v) = %+v, %v", tt.headers, sc, ok)
+		}
+	}
+}
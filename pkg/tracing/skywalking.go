@@ -0,0 +1,204 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/SkyAPM/go2sky"
+	swpropagation "github.com/SkyAPM/go2sky/propagation"
+	"github.com/SkyAPM/go2sky/reporter"
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/metadata"
+	"httpbin/pkg/options"
+	agentv3 "skywalking.apache.org/repo/goapi/collect/language/agent/v3"
+)
+
+const (
+	componentIDHttpClient    = 2
+	componentIDGRPCServer    = 23
+	componentIDGRPCClient    = 23
+	componentIDGINHttpServer = 5006
+)
+
+// skywalkingTags maps the tags to the SkyWalking tags.
+var skywalkingTags = map[string]go2sky.Tag{
+	TagHTTPURL:        go2sky.TagURL,
+	TagHTTPStatusCode: go2sky.TagStatusCode,
+}
+
+// SkywalkingTracer reports to the SkyWalking OAP of --skywalking-grpc-address and the
+// recorder, propagating the sw8 header. The entry spans are sampled by the Sampler, the
+// sampled flag of sw8 or else of the --trace-extract formats is the upstream decision.
+type SkywalkingTracer struct {
+	tracer      *go2sky.Tracer
+	reporter    go2sky.Reporter
+	propagation *Propagation
+	sampler     *Sampler
+}
+
+func NewSkywalkingTracer(option *options.Option, recorder *Recorder) (Tracer, error) {
+	if len(option.SkywalkingGrpcAddress) == 0 && recorder == nil {
+		return noopTracer("skywalking", "skywalking-grpc-address"), nil
+	}
+	propagation, err := NewPropagation(option)
+	if err != nil {
+		return nil, err
+	}
+	sampler, err := NewSampler(option)
+	if err != nil {
+		return nil, err
+	}
+	var r go2sky.Reporter
+	if len(option.SkywalkingGrpcAddress) > 0 {
+		if r, err = reporter.NewGRPCReporter(option.SkywalkingGrpcAddress); err != nil {
+			return nil, fmt.Errorf("create gosky reporter failed: %v", err)
+		}
+	}
+	if recorder != nil {
+		r = skywalkingRecorder{next: r, recorder: recorder, service: option.ServiceName}
+	}
+	tracer, err := go2sky.NewTracer(option.ServiceName, go2sky.WithReporter(r),
+		go2sky.WithInstance(option.InstanceName),
+		go2sky.WithCustomSampler(go2sky.NewConstSampler(true)))
+	if err != nil {
+		return nil, fmt.Errorf("create gosky tracer failed: %v", err)
+	}
+	return &SkywalkingTracer{tracer: tracer, reporter: r, propagation: propagation, sampler: sampler}, nil
+}
+
+func (t *SkywalkingTracer) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if skipTrace(c.Request) {
+			c.Next()
+			return
+		}
+		ctx, sampled := t.sample(c.Request.Context(), c.FullPath(), headerCarrier(c.Request.Header))
+		if !sampled {
+			c.Request = c.Request.WithContext(ctx)
+			c.Next()
+			return
+		}
+		span, ctx, err := t.tracer.CreateEntrySpan(ctx, getOperationName(c), func(key string) (string, error) {
+			return c.Request.Header.Get(key), nil
+		})
+		if err != nil {
+			c.Next()
+			return
+		}
+		span.SetComponent(componentIDGINHttpServer)
+		span.Tag(go2sky.TagHTTPMethod, c.Request.Method)
+		span.Tag(go2sky.TagURL, c.Request.Host+c.Request.URL.Path)
+		span.SetSpanLayer(agentv3.SpanLayer_Http)
+
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+
+		if len(c.Errors) > 0 {
+			span.Error(time.Now(), c.Errors.String())
+		}
+		span.Tag(go2sky.TagStatusCode, strconv.Itoa(c.Writer.Status()))
+		span.End()
+	}
+}
+
+func (t *SkywalkingTracer) StartGrpcServerSpan(ctx context.Context, method string, md metadata.MD) (context.Context, Span) {
+	ctx, sampled := t.sample(ctx, method, metadataCarrier(md))
+	if !sampled {
+		return ctx, noopSpan{}
+	}
+	span, spanCtx, err := t.tracer.CreateEntrySpan(ctx, method, func(key string) (string, error) {
+		if values := md.Get(key); len(values) > 0 {
+			return values[0], nil
+		}
+		return "", nil
+	})
+	if err != nil {
+		return ctx, noopSpan{}
+	}
+	span.SetComponent(componentIDGRPCServer)
+	span.SetSpanLayer(agentv3.SpanLayer_RPCFramework)
+	span.Tag(go2sky.TagURL, method)
+	return spanCtx, skywalkingSpan{span}
+}
+
+// sample decides the sampling of a request to route. The requests not sampled get a noop
+// span, so that their exit spans are not sampled as new traces.
+func (t *SkywalkingTracer) sample(ctx context.Context, route string, carrier Carrier) (context.Context, bool) {
+	var upstream *SpanContext
+	sw8 := &swpropagation.SpanContext{}
+	if err := sw8.DecodeSW8(carrier.Get(swpropagation.Header)); err == nil {
+		sampled := sw8.Sample != 0
+		upstream = &SpanContext{Sampled: &sampled}
+	} else if sc, ok := t.propagation.Extract(carrier); ok {
+		upstream = &sc
+	}
+	sampling := t.sampler.Sample(route, carrier, upstream)
+	ctx = contextWithSampling(ctx, sampling)
+	if !sampling.Sampled {
+		ctx = go2sky.WithSpan(ctx, &go2sky.NoopSpan{})
+	}
+	return ctx, sampling.Sampled
+}
+
+func (t *SkywalkingTracer) StartHttpClientSpan(ctx context.Context, req *http.Request) Span {
+	url := req.URL.String()
+	span, err := t.tracer.CreateExitSpan(ctx, "invoke", url, func(headerKey, headerValue string) error {
+		setHeader(req.Header, headerKey, headerValue)
+		return nil
+	})
+	if err != nil {
+		return noopSpan{}
+	}
+	span.SetComponent(componentIDHttpClient)
+	span.SetSpanLayer(agentv3.SpanLayer_Http) // rpc 调用
+	span.Tag(go2sky.TagHTTPMethod, req.Method)
+	span.Tag(go2sky.TagURL, url)
+	return skywalkingSpan{span}
+}
+
+func (t *SkywalkingTracer) StartGrpcClientSpan(ctx context.Context, address string, method string, md metadata.MD) Span {
+	span, err := t.tracer.CreateExitSpan(ctx, method, address, func(headerKey, headerValue string) error {
+		md.Set(headerKey, headerValue)
+		return nil
+	})
+	if err != nil {
+		return noopSpan{}
+	}
+	span.SetComponent(componentIDGRPCClient)
+	span.SetSpanLayer(agentv3.SpanLayer_RPCFramework)
+	span.Tag(go2sky.TagURL, address+method)
+	return skywalkingSpan{span}
+}
+
+func (t *SkywalkingTracer) Shutdown(ctx context.Context) error {
+	t.reporter.Close()
+	return nil
+}
+
+type skywalkingSpan struct {
+	span go2sky.Span
+}
+
+func (s skywalkingSpan) Tag(key string, value string) {
+	if tag, ok := skywalkingTags[key]; ok {
+		s.span.Tag(tag, value)
+		return
+	}
+	s.span.Tag(go2sky.Tag(key), value)
+}
+
+func (s skywalkingSpan) Error(message string) {
+	s.span.Error(time.Now(), message)
+}
+
+func (s skywalkingSpan) End() {
+	s.span.End()
+}
+
+func getOperationName(c *gin.Context) string {
+	return fmt.Sprintf("/%s%s", c.Request.Method, c.FullPath())
+}
@@ -0,0 +1,153 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc/metadata"
+	"httpbin/pkg/logs"
+	"httpbin/pkg/options"
+)
+
+// Tags set on the spans by the handlers, each tracer maps them to the names of its provider.
+const (
+	TagHTTPMethod     = "http.method"
+	TagHTTPURL        = "http.url"
+	TagHTTPStatusCode = "http.status_code"
+	TagGRPCStatusCode = "grpc.status_code"
+	TagPeerAddress    = "peer.address"
+)
+
+const (
+	skipProbPrefix    = "/prob/"
+	skipMetricsPrefix = "/metrics"
+	skipDebugPrefix   = "/debug/"
+)
+
+// Tracer traces the http and grpc requests served by httpbin and the calls to the next hops.
+type Tracer interface {
+	// Middleware returns the gin middleware starting an entry span per request.
+	Middleware() gin.HandlerFunc
+	// StartGrpcServerSpan starts the entry span of a grpc call of method, continuing the trace in md.
+	StartGrpcServerSpan(ctx context.Context, method string, md metadata.MD) (context.Context, Span)
+	// StartHttpClientSpan starts the exit span of req, injecting the trace headers into req.
+	StartHttpClientSpan(ctx context.Context, req *http.Request) Span
+	// StartGrpcClientSpan starts the exit span of a grpc call of method to address, injecting
+	// the trace into md.
+	StartGrpcClientSpan(ctx context.Context, address string, method string, md metadata.MD) Span
+	// Shutdown reports the pending spans.
+	Shutdown(ctx context.Context) error
+}
+
+// Span is a span started by a Tracer.
+type Span interface {
+	Tag(key string, value string)
+	// Error marks the span failed.
+	Error(message string)
+	End()
+}
+
+// NewTracer returns the tracer of --trace-provider, a no-op tracer without it. The finished
+// spans are also recorded by recorder if set.
+func NewTracer(ctx context.Context, option *options.Option, recorder *Recorder) (Tracer, error) {
+	switch option.TraceProvider {
+	case "":
+		if recorder != nil {
+			logs.Warnf("no spans recorded by --trace-buffer-size and --trace-export without --trace-provider")
+		}
+		return NewNoopTracer(option)
+	case options.Skywalking:
+		return NewSkywalkingTracer(option, recorder)
+	case options.Zipkin:
+		return NewZipkinTracer(option, recorder)
+	case options.Otel:
+		return NewOtelTracer(ctx, option, recorder)
+	}
+	return nil, fmt.Errorf("unknown trace provider %s", option.TraceProvider)
+}
+
+// NoopTracer starts no spans. With --trace-inject it passes the extracted trace on to the
+// next hops in the injected formats, translating the trace headers.
+type NoopTracer struct {
+	propagation *Propagation
+}
+
+func NewNoopTracer(option *options.Option) (Tracer, error) {
+	propagation, err := NewPropagation(option)
+	if err != nil {
+		return nil, err
+	}
+	return &NoopTracer{propagation: propagation}, nil
+}
+
+func (t *NoopTracer) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if t.propagation != nil && t.propagation.Injects() {
+			if sc, ok := t.propagation.Extract(headerCarrier(c.Request.Header)); ok {
+				c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), spanContextKey{}, sc))
+			}
+		}
+		c.Next()
+	}
+}
+
+func (t *NoopTracer) StartGrpcServerSpan(ctx context.Context, method string, md metadata.MD) (context.Context, Span) {
+	if t.propagation != nil && t.propagation.Injects() {
+		if sc, ok := t.propagation.Extract(metadataCarrier(md)); ok {
+			ctx = context.WithValue(ctx, spanContextKey{}, sc)
+		}
+	}
+	return ctx, noopSpan{}
+}
+
+func (t *NoopTracer) StartHttpClientSpan(ctx context.Context, req *http.Request) Span {
+	if sc, ok := ctx.Value(spanContextKey{}).(SpanContext); ok {
+		t.propagation.Inject(sc, headerCarrier(req.Header))
+	}
+	return noopSpan{}
+}
+
+func (t *NoopTracer) StartGrpcClientSpan(ctx context.Context, address string, method string, md metadata.MD) Span {
+	if sc, ok := ctx.Value(spanContextKey{}).(SpanContext); ok {
+		t.propagation.Inject(sc, metadataCarrier(md))
+	}
+	return noopSpan{}
+}
+
+func (t *NoopTracer) Shutdown(ctx context.Context) error {
+	return nil
+}
+
+// spanContextKey is the context key of the SpanContext extracted by the NoopTracer.
+type spanContextKey struct{}
+
+type noopSpan struct{}
+
+func (noopSpan) Tag(key string, value string) {}
+
+func (noopSpan) Error(message string) {}
+
+func (noopSpan) End() {}
+
+// noopTracer returns the no-op tracer of a provider missing its reporter address and
+// the recorder.
+func noopTracer(provider string, flag string) Tracer {
+	logs.Warnf("%s tracing disabled without --%s or --trace-buffer-size and --trace-export", provider, flag)
+	return &NoopTracer{}
+}
+
+// skipTrace skips the probes, metrics scraping and debug endpoints.
+func skipTrace(req *http.Request) bool {
+	return strings.HasPrefix(req.URL.Path, skipProbPrefix) || strings.HasPrefix(req.URL.Path, skipMetricsPrefix) ||
+		strings.HasPrefix(req.URL.Path, skipDebugPrefix)
+}
+
+// setHeader sets a trace header of a next hop, replacing the lower case header passed on
+// from the incoming request.
+func setHeader(header http.Header, key string, value string) {
+	delete(header, strings.ToLower(key))
+	header.Set(key, value)
+}
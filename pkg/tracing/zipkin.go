@@ -0,0 +1,160 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/openzipkin/zipkin-go"
+	"github.com/openzipkin/zipkin-go/model"
+	"github.com/openzipkin/zipkin-go/reporter"
+	reporterhttp "github.com/openzipkin/zipkin-go/reporter/http"
+	"google.golang.org/grpc/metadata"
+	"httpbin/pkg/options"
+)
+
+// ZipkinTracer reports to the Zipkin collector of --zipkin-endpoint-url and the recorder,
+// propagating the B3 headers without --trace-inject. The entry spans are sampled by the
+// Sampler, the exit spans follow them.
+type ZipkinTracer struct {
+	tracer      *zipkin.Tracer
+	reporter    reporter.Reporter
+	propagation *Propagation
+	sampler     *Sampler
+}
+
+func NewZipkinTracer(option *options.Option, recorder *Recorder) (Tracer, error) {
+	if len(option.ZipkinEndpointURL) == 0 && recorder == nil {
+		return noopTracer("zipkin", "zipkin-endpoint-url"), nil
+	}
+	propagation, err := NewPropagation(option, options.PropagationB3Multi)
+	if err != nil {
+		return nil, err
+	}
+	sampler, err := NewSampler(option)
+	if err != nil {
+		return nil, err
+	}
+	var r reporter.Reporter
+	if len(option.ZipkinEndpointURL) > 0 {
+		r = reporterhttp.NewReporter(option.ZipkinEndpointURL)
+	}
+	if recorder != nil {
+		r = zipkinRecorder{next: r, recorder: recorder}
+	}
+	localEndpoint := &model.Endpoint{ServiceName: option.ServiceName, Port: uint16(option.ServerPort)}
+
+	tracer, err := zipkin.NewTracer(
+		r,
+		zipkin.WithLocalEndpoint(localEndpoint),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("create zipkin tracer failed: %v", err)
+	}
+	return &ZipkinTracer{tracer: tracer, reporter: r, propagation: propagation, sampler: sampler}, nil
+}
+
+func (t *ZipkinTracer) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if skipTrace(c.Request) {
+			c.Next()
+			return
+		}
+		newCtx, span := t.startServerSpan(c.Request.Context(), c.Request.URL.Path, c.FullPath(), headerCarrier(c.Request.Header))
+		zipkin.TagHTTPMethod.Set(span, c.Request.Method)
+		zipkin.TagHTTPUrl.Set(span, c.Request.Host+c.Request.URL.Path)
+
+		c.Request = c.Request.WithContext(newCtx)
+
+		c.Next()
+
+		zipkin.TagHTTPStatusCode.Set(span, strconv.Itoa(c.Writer.Status()))
+		span.Finish()
+	}
+}
+
+func (t *ZipkinTracer) StartGrpcServerSpan(ctx context.Context, method string, md metadata.MD) (context.Context, Span) {
+	ctx, span := t.startServerSpan(ctx, method, method, metadataCarrier(md))
+	return ctx, zipkinSpan{span}
+}
+
+// startServerSpan starts the entry span of a request to route, continuing the trace in
+// carrier with the decision of the sampler.
+func (t *ZipkinTracer) startServerSpan(ctx context.Context, name string, route string, carrier Carrier) (context.Context, zipkin.Span) {
+	var upstream *SpanContext
+	var parent model.SpanContext
+	if sc, ok := t.propagation.Extract(carrier); ok {
+		upstream, parent = &sc, zipkinSpanContext(sc)
+	}
+	sampling := t.sampler.Sample(route, carrier, upstream)
+	// Without an upstream trace the span is the root of a new trace of the decision.
+	parent.Sampled = &sampling.Sampled
+	parent.Debug = sampling.Reason == SamplingDebug
+	span := t.tracer.StartSpan(name, zipkin.Kind(model.Server), zipkin.Parent(parent))
+	return contextWithSampling(zipkin.NewContext(ctx, span), sampling), span
+}
+
+func (t *ZipkinTracer) StartHttpClientSpan(ctx context.Context, req *http.Request) Span {
+	span, _ := t.tracer.StartSpanFromContext(ctx, "invoke", zipkin.Kind(model.Client))
+	t.propagation.Inject(fromZipkinSpanContext(span.Context()), headerCarrier(req.Header))
+	zipkin.TagHTTPMethod.Set(span, req.Method)
+	zipkin.TagHTTPUrl.Set(span, req.URL.String())
+	return zipkinSpan{span}
+}
+
+func (t *ZipkinTracer) StartGrpcClientSpan(ctx context.Context, address string, method string, md metadata.MD) Span {
+	span, _ := t.tracer.StartSpanFromContext(ctx, method, zipkin.Kind(model.Client))
+	t.propagation.Inject(fromZipkinSpanContext(span.Context()), metadataCarrier(md))
+	span.Tag("grpc.address", address)
+	return zipkinSpan{span}
+}
+
+func (t *ZipkinTracer) Shutdown(ctx context.Context) error {
+	return t.reporter.Close()
+}
+
+type zipkinSpan struct {
+	span zipkin.Span
+}
+
+func (s zipkinSpan) Tag(key string, value string) {
+	s.span.Tag(key, value)
+}
+
+func (s zipkinSpan) Error(message string) {
+	zipkin.TagError.Set(s.span, message)
+}
+
+func (s zipkinSpan) End() {
+	s.span.Finish()
+}
+
+func zipkinSpanContext(sc SpanContext) model.SpanContext {
+	zc := model.SpanContext{
+		TraceID: model.TraceID{High: sc.TraceIDHigh, Low: sc.TraceID},
+		ID:      model.ID(sc.SpanID),
+		Debug:   sc.Debug,
+		Sampled: sc.Sampled,
+	}
+	if sc.ParentID != 0 {
+		parentID := model.ID(sc.ParentID)
+		zc.ParentID = &parentID
+	}
+	return zc
+}
+
+func fromZipkinSpanContext(zc model.SpanContext) SpanContext {
+	sc := SpanContext{
+		TraceIDHigh: zc.TraceID.High,
+		TraceID:     zc.TraceID.Low,
+		SpanID:      uint64(zc.ID),
+		Debug:       zc.Debug,
+		Sampled:     zc.Sampled,
+	}
+	if zc.ParentID != nil {
+		sc.ParentID = uint64(*zc.ParentID)
+	}
+	return sc
+}
@@ -0,0 +1,179 @@
+package transcoding
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// serverStream adapts a http request to grpc.ServerStream. Received messages are read
+// from the body as a JSON array or newline delimited JSON, sent messages are written as
+// newline delimited JSON and flushed one by one.
+type serverStream struct {
+	c       *gin.Context
+	binding *binding
+	desc    grpc.StreamDesc
+	ctx     context.Context
+	decoder *json.Decoder
+	array   bool
+	recv    bool
+	sent    bool
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+func newServerStream(c *gin.Context, b *binding, desc grpc.StreamDesc) *serverStream {
+	return &serverStream{
+		c:       c,
+		binding: b,
+		desc:    desc,
+		ctx:     incomingContext(c),
+		header:  metadata.MD{},
+		trailer: metadata.MD{},
+	}
+}
+
+func (s *serverStream) SetHeader(md metadata.MD) error {
+	if s.sent {
+		return status.Error(codes.Internal, "header already sent")
+	}
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *serverStream) SendHeader(md metadata.MD) error {
+	if err := s.SetHeader(md); err != nil {
+		return err
+	}
+	s.writeHeader(http.StatusOK)
+	return nil
+}
+
+func (s *serverStream) SetTrailer(md metadata.MD) {
+	s.trailer = metadata.Join(s.trailer, md)
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *serverStream) SendMsg(m interface{}) error {
+	content, err := marshalOptions.Marshal(m.(proto.Message))
+	if err != nil {
+		return err
+	}
+	s.writeHeader(http.StatusOK)
+	if _, err := s.c.Writer.Write(append(content, '\n')); err != nil {
+		return err
+	}
+	s.c.Writer.Flush()
+	return nil
+}
+
+func (s *serverStream) RecvMsg(m interface{}) error {
+	if !s.desc.ClientStreams {
+		// A single request built from path, query and body like a unary call.
+		if s.recv {
+			return io.EOF
+		}
+		s.recv = true
+		return s.binding.decode(s.c, m.(proto.Message))
+	}
+	if s.decoder == nil {
+		if err := s.initDecoder(); err != nil {
+			return err
+		}
+	}
+	if s.array && !s.decoder.More() {
+		return io.EOF
+	}
+	var raw json.RawMessage
+	if err := s.decoder.Decode(&raw); err != nil {
+		if err == io.EOF {
+			return err
+		}
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := unmarshalOptions.Unmarshal(raw, m.(proto.Message)); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+func (s *serverStream) initDecoder() error {
+	reader := bufio.NewReader(s.c.Request.Body)
+	s.decoder = json.NewDecoder(reader)
+	for {
+		b, err := reader.Peek(1)
+		if err != nil {
+			// Empty body, the first Decode returns io.EOF.
+			return nil
+		}
+		switch b[0] {
+		case ' ', '\t', '\r', '\n':
+			_, _ = reader.ReadByte()
+			continue
+		case '[':
+			s.array = true
+			if _, err := s.decoder.Token(); err != nil {
+				return status.Error(codes.InvalidArgument, err.Error())
+			}
+		}
+		return nil
+	}
+}
+
+func (s *serverStream) writeHeader(code int) {
+	if s.sent {
+		return
+	}
+	s.sent = true
+	writeMetadata(s.c.Writer.Header(), metadataHeaderPrefix, s.header)
+	if s.desc.ServerStreams {
+		s.c.Writer.Header().Set("Content-Type", ContentTypeNDJson)
+	} else {
+		s.c.Writer.Header().Set("Content-Type", "application/json")
+	}
+	s.c.Writer.WriteHeader(code)
+	s.c.Writer.WriteHeaderNow()
+}
+
+// writeError reports err as the http status if nothing was sent yet, otherwise as a
+// last {"error": ...} line of the stream.
+func (s *serverStream) writeError(err error) {
+	if !s.sent {
+		s.sent = true
+		writeError(s.c, err)
+		return
+	}
+	st := status.Convert(err)
+	content, _ := json.Marshal(gin.H{"error": gin.H{
+		"code":    st.Code().String(),
+		"message": st.Message(),
+	}})
+	_, _ = s.c.Writer.Write(append(content, '\n'))
+	s.c.Writer.Flush()
+}
+
+// writeTrailer sends the trailers as http trailers after the streamed body, or as headers
+// if nothing was sent.
+func (s *serverStream) writeTrailer() {
+	if len(s.trailer) == 0 {
+		return
+	}
+	if !s.sent {
+		writeMetadata(s.c.Writer.Header(), metadataTrailerPrefix, s.trailer)
+		s.writeHeader(http.StatusOK)
+		return
+	}
+	writeMetadata(s.c.Writer.Header(), http.TrailerPrefix+metadataTrailerPrefix, s.trailer)
+}
@@ -0,0 +1,411 @@
+package transcoding
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+const ContentTypeNDJson = "application/x-ndjson"
+
+var (
+	marshalOptions   = protojson.MarshalOptions{}
+	unmarshalOptions = protojson.UnmarshalOptions{DiscardUnknown: true}
+)
+
+type Option func(*transcoder)
+
+// WithQueryAlias binds query parameter alias to field of the request message,
+// e.g. WithQueryAlias("q", "value") for google.protobuf.StringValue requests.
+func WithQueryAlias(alias string, field string) Option {
+	return func(t *transcoder) {
+		t.aliases[alias] = field
+	}
+}
+
+// WithUnaryInterceptor runs the unary calls through interceptor like the grpc server does,
+// e.g. for tracing, metrics and access logs.
+func WithUnaryInterceptor(interceptor grpc.UnaryServerInterceptor) Option {
+	return func(t *transcoder) {
+		t.unaryInterceptor = interceptor
+	}
+}
+
+// WithStreamInterceptor runs the streaming calls through interceptor like the grpc server does.
+func WithStreamInterceptor(interceptor grpc.StreamServerInterceptor) Option {
+	return func(t *transcoder) {
+		t.streamInterceptor = interceptor
+	}
+}
+
+type transcoder struct {
+	aliases           map[string]string
+	unaryInterceptor  grpc.UnaryServerInterceptor
+	streamInterceptor grpc.StreamServerInterceptor
+}
+
+// binding is a http route of a grpc method declared by its google.api.http option.
+type binding struct {
+	*transcoder
+	method     protoreflect.MethodDescriptor
+	body       string
+	pathFields []string
+}
+
+// Register adds a gin route for every method of the service which has a google.api.http
+// option, dispatching to srv through the generated grpc handlers. Streamed requests and
+// responses are newline delimited JSON, a client streamed body may also be a JSON array.
+func Register(r gin.IRoutes, desc *grpc.ServiceDesc, srv interface{}, opts ...Option) error {
+	t := &transcoder{aliases: make(map[string]string)}
+	for _, opt := range opts {
+		opt(t)
+	}
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(protoreflect.FullName(desc.ServiceName))
+	if err != nil {
+		return err
+	}
+	service, ok := d.(protoreflect.ServiceDescriptor)
+	if !ok {
+		return fmt.Errorf("%s is not a service", desc.ServiceName)
+	}
+	for i := range desc.Methods {
+		methodDesc := desc.Methods[i]
+		rules, err := t.bindings(service, methodDesc.MethodName)
+		if err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			b := rule
+			r.Handle(rule.verb, rule.path, func(c *gin.Context) {
+				b.unary(c, srv, methodDesc)
+			})
+		}
+	}
+	for i := range desc.Streams {
+		streamDesc := desc.Streams[i]
+		rules, err := t.bindings(service, streamDesc.StreamName)
+		if err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			b := rule
+			r.Handle(rule.verb, rule.path, func(c *gin.Context) {
+				b.stream(c, srv, streamDesc)
+			})
+		}
+	}
+	return nil
+}
+
+type route struct {
+	*binding
+	verb string
+	path string
+}
+
+func (t *transcoder) bindings(service protoreflect.ServiceDescriptor, name string) ([]route, error) {
+	method := service.Methods().ByName(protoreflect.Name(name))
+	if method == nil {
+		return nil, fmt.Errorf("method %s not found in %s", name, service.FullName())
+	}
+	rule, ok := proto.GetExtension(method.Options(), annotations.E_Http).(*annotations.HttpRule)
+	if !ok || rule == nil {
+		return nil, nil
+	}
+	routes := make([]route, 0, 1+len(rule.AdditionalBindings))
+	for _, r := range append([]*annotations.HttpRule{rule}, rule.AdditionalBindings...) {
+		verb, template := pattern(r)
+		if len(template) == 0 {
+			return nil, fmt.Errorf("method %s: unsupported http rule %v", method.FullName(), r)
+		}
+		path, fields, err := ginPath(template)
+		if err != nil {
+			return nil, fmt.Errorf("method %s: %v", method.FullName(), err)
+		}
+		routes = append(routes, route{
+			binding: &binding{transcoder: t, method: method, body: r.Body, pathFields: fields},
+			verb:    verb,
+			path:    path,
+		})
+	}
+	return routes, nil
+}
+
+func pattern(rule *annotations.HttpRule) (string, string) {
+	switch p := rule.Pattern.(type) {
+	case *annotations.HttpRule_Get:
+		return http.MethodGet, p.Get
+	case *annotations.HttpRule_Put:
+		return http.MethodPut, p.Put
+	case *annotations.HttpRule_Post:
+		return http.MethodPost, p.Post
+	case *annotations.HttpRule_Delete:
+		return http.MethodDelete, p.Delete
+	case *annotations.HttpRule_Patch:
+		return http.MethodPatch, p.Patch
+	case *annotations.HttpRule_Custom:
+		return p.Custom.Kind, p.Custom.Path
+	}
+	return "", ""
+}
+
+// ginPath converts a path template like /orders/{value} to /orders/:value. Only single
+// segment variables are supported.
+func ginPath(template string) (string, []string, error) {
+	segments := strings.Split(template, "/")
+	fields := make([]string, 0)
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, "{") {
+			continue
+		}
+		if !strings.HasSuffix(segment, "}") || strings.Contains(segment, "=") {
+			return "", nil, fmt.Errorf("unsupported path template %s", template)
+		}
+		field := segment[1 : len(segment)-1]
+		fields = append(fields, field)
+		segments[i] = ":" + field
+	}
+	return strings.Join(segments, "/"), fields, nil
+}
+
+func (b *binding) unary(c *gin.Context, srv interface{}, desc grpc.MethodDesc) {
+	dec := func(m interface{}) error {
+		return b.decode(c, m.(proto.Message))
+	}
+	ts := &transportStream{method: b.fullMethod(), header: metadata.MD{}, trailer: metadata.MD{}}
+	ctx := grpc.NewContextWithServerTransportStream(incomingContext(c), ts)
+	resp, err := desc.Handler(srv, ctx, dec, b.unaryInterceptor)
+	// The body is written at once, so the trailers are sent as headers.
+	writeMetadata(c.Writer.Header(), metadataHeaderPrefix, ts.header)
+	writeMetadata(c.Writer.Header(), metadataTrailerPrefix, ts.trailer)
+	if err != nil {
+		writeError(c, err)
+		return
+	}
+	content, err := marshalOptions.Marshal(resp.(proto.Message))
+	if err != nil {
+		writeError(c, status.Error(codes.Internal, err.Error()))
+		return
+	}
+	c.Data(http.StatusOK, "application/json", content)
+}
+
+func (b *binding) stream(c *gin.Context, srv interface{}, desc grpc.StreamDesc) {
+	stream := newServerStream(c, b, desc)
+	var err error
+	if b.streamInterceptor != nil {
+		info := &grpc.StreamServerInfo{
+			FullMethod:     b.fullMethod(),
+			IsClientStream: desc.ClientStreams,
+			IsServerStream: desc.ServerStreams,
+		}
+		err = b.streamInterceptor(srv, stream, info, desc.Handler)
+	} else {
+		err = desc.Handler(srv, stream)
+	}
+	if err != nil {
+		stream.writeError(err)
+	}
+	stream.writeTrailer()
+}
+
+// fullMethod returns the grpc method name like /order.OrderManagement/getOrder.
+func (b *binding) fullMethod() string {
+	return fmt.Sprintf("/%s/%s", b.method.Parent().FullName(), b.method.Name())
+}
+
+// decode fills m from the request body, path variables and query parameters.
+func (b *binding) decode(c *gin.Context, m proto.Message) error {
+	msg := m.ProtoReflect()
+	if len(b.body) > 0 {
+		content, err := c.GetRawData()
+		if err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		if len(content) > 0 {
+			if err := b.unmarshalBody(content, msg); err != nil {
+				return err
+			}
+		}
+	}
+	for _, field := range b.pathFields {
+		if err := setField(msg, field, []string{c.Param(field)}); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if b.body == "*" {
+		return nil
+	}
+	for key, values := range c.Request.URL.Query() {
+		if field, ok := b.aliases[key]; ok {
+			key = field
+		}
+		if b.isPathField(key) || (len(b.body) > 0 && strings.HasPrefix(key+".", b.body+".")) {
+			continue
+		}
+		if err := setField(msg, key, values); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	return nil
+}
+
+func (b *binding) unmarshalBody(content []byte, msg protoreflect.Message) error {
+	target := msg
+	if b.body != "*" {
+		fd := msg.Descriptor().Fields().ByName(protoreflect.Name(b.body))
+		if fd == nil || fd.Kind() != protoreflect.MessageKind || fd.IsList() || fd.IsMap() {
+			return status.Errorf(codes.Internal, "unsupported body field %s", b.body)
+		}
+		target = msg.Mutable(fd).Message()
+	}
+	if err := unmarshalOptions.Unmarshal(content, target.Interface()); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+func (b *binding) isPathField(field string) bool {
+	for _, f := range b.pathFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// incomingContext exposes the http headers as grpc metadata and the client address as
+// the grpc peer to the handler.
+func incomingContext(c *gin.Context) context.Context {
+	md := metadata.MD{}
+	for key, values := range c.Request.Header {
+		md.Append(strings.ToLower(key), values...)
+	}
+	ctx := metadata.NewIncomingContext(c.Request.Context(), md)
+	if addr, err := net.ResolveTCPAddr("tcp", c.Request.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: addr})
+	}
+	return ctx
+}
+
+// Prefixes of the response headers carrying the grpc header and trailer metadata, as in
+// grpc-gateway.
+const (
+	metadataHeaderPrefix  = "Grpc-Metadata-"
+	metadataTrailerPrefix = "Grpc-Trailer-"
+)
+
+func writeMetadata(h http.Header, prefix string, md metadata.MD) {
+	for key, values := range md {
+		for _, value := range values {
+			h.Add(prefix+key, value)
+		}
+	}
+}
+
+// transportStream keeps the metadata set by grpc.SetHeader and grpc.SetTrailer in unary
+// handlers and interceptors.
+type transportStream struct {
+	method  string
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+func (s *transportStream) Method() string {
+	return s.method
+}
+
+func (s *transportStream) SetHeader(md metadata.MD) error {
+	s.header = metadata.Join(s.header, md)
+	return nil
+}
+
+func (s *transportStream) SendHeader(md metadata.MD) error {
+	return s.SetHeader(md)
+}
+
+func (s *transportStream) SetTrailer(md metadata.MD) error {
+	s.trailer = metadata.Join(s.trailer, md)
+	return nil
+}
+
+func writeError(c *gin.Context, err error) {
+	s := status.Convert(err)
+	c.JSON(HTTPStatusFromCode(s.Code()), gin.H{
+		"code":    s.Code().String(),
+		"message": s.Message(),
+	})
+}
+
+// HTTPStatusFromCode maps a grpc code to the http status used by grpc-gateway and Envoy.
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// CodeFromHTTPStatus maps an http status to a grpc code like the grpc http status mapping.
+func CodeFromHTTPStatus(status int) codes.Code {
+	switch status {
+	case http.StatusOK:
+		return codes.OK
+	case 499:
+		return codes.Canceled
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	if status >= 200 && status < 300 {
+		return codes.OK
+	}
+	return codes.Unknown
+}
@@ -1,7 +1,9 @@
 package utils
 
 import (
+	"bufio"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -13,6 +15,9 @@ const (
 	EnvVersion     = "VERSION"
 	EnvPODIP       = "POD_IP"
 	EnvNodeName    = "NODE_NAME"
+	EnvZone        = "ZONE"
+
+	EnvServiceMetaPrefix = "SERVICE_META_"
 )
 
 func GetAllEnvs() map[string]string {
@@ -53,6 +58,60 @@ func GetServiceName() string {
 	return GetStringEnv(EnvServiceName, GetDefaultHostName())
 }
 
+func GetZone() string {
+	return GetStringEnv(EnvZone, "")
+}
+
+// GetServiceMetaEnvs returns SERVICE_META_* envs as metadata, e.g. SERVICE_META_TEAM=a gives team=a.
+func GetServiceMetaEnvs() map[string]string {
+	meta := make(map[string]string)
+	for k, v := range GetAllEnvs() {
+		if strings.HasPrefix(k, EnvServiceMetaPrefix) && len(k) > len(EnvServiceMetaPrefix) {
+			meta[strings.ToLower(strings.TrimPrefix(k, EnvServiceMetaPrefix))] = v
+		}
+	}
+	return meta
+}
+
+// ReadPodInfoFile reads a Kubernetes downward API labels or annotations file,
+// one key="value" per line. A missing file gives empty result.
+func ReadPodInfoFile(path string) (map[string]string, error) {
+	podInfo := make(map[string]string)
+	file, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return podInfo, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		parts := strings.SplitN(scanner.Text(), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		value, err := strconv.Unquote(parts[1])
+		if err != nil {
+			value = parts[1]
+		}
+		podInfo[parts[0]] = value
+	}
+	return podInfo, scanner.Err()
+}
+
+// SplitNonEmpty splits s by sep and drops empty items, so an empty s gives no items.
+func SplitNonEmpty(s string, sep string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, sep) {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
 func GetSubSystem() string {
 	return GetStringEnv(EnvSubSystem, "")
 }